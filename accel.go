@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+)
+
+// checkAcceleration logs the hardware acceleration findings of the host with their remediation,
+// and returns if x86 emulator images can be run with acceleration.
+// The emulator -accel-check is skipped if the emulator is not installed yet.
+func checkAcceleration(androidHome string) bool {
+	emu, err := emulator.New(androidHome)
+	if err != nil {
+		log.Printf("Emulator not found, skipping emulator -accel-check")
+		emu = nil
+	}
+
+	report := emulator.NewAccelChecker(emu).Check()
+	printAccelFindings(report)
+	return report.Usable
+}
+
+// needsAcceleration returns if the emulator images of the ABI can only be run with hardware acceleration.
+func needsAcceleration(abi string) bool {
+	return abi == "x86" || abi == "x86_64"
+}
+
+// printAccelFindings logs the findings with their fixes.
+func printAccelFindings(report emulator.AccelReport) {
+	for _, finding := range report.Findings {
+		switch finding.Status {
+		case emulator.FindingOK:
+			log.Donef("- %s: %s", finding.Check, finding.Message)
+		case emulator.FindingInfo:
+			log.Printf("- %s: %s", finding.Check, finding.Message)
+		case emulator.FindingWarning:
+			log.Warnf("- %s: %s", finding.Check, finding.Message)
+		default:
+			log.Errorf("- %s: %s", finding.Check, finding.Message)
+		}
+
+		if finding.Fix != "" {
+			log.Printf("  Fix: %s", finding.Fix)
+		}
+	}
+}
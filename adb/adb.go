@@ -0,0 +1,335 @@
+package adb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAddress is the address of the local adb server.
+const DefaultAddress = "localhost:5037"
+
+// DefaultTimeout is the dial timeout, and the deadline of a request and of every read of its response.
+const DefaultTimeout = 30 * time.Second
+
+// Client talks to the adb server over its smart socket protocol.
+type Client struct {
+	Address string
+	Timeout time.Duration
+}
+
+// Device is an entry of the host:devices-l response,
+// the product, model and device fields are only known for the online devices.
+type Device struct {
+	Serial      string
+	State       string
+	Product     string
+	Model       string
+	Device      string
+	TransportID string
+}
+
+// New creates a client for the local adb server,
+// the port can be overridden by the ANDROID_ADB_SERVER_PORT env var, like for the adb command.
+func New() Client {
+	address := DefaultAddress
+	if port := os.Getenv("ANDROID_ADB_SERVER_PORT"); port != "" {
+		address = net.JoinHostPort("localhost", port)
+	}
+	return Client{Address: address, Timeout: DefaultTimeout}
+}
+
+// ServerError is the FAIL response of the adb server.
+type ServerError struct {
+	Request string
+	Message string
+}
+
+// Error ...
+func (e ServerError) Error() string {
+	return fmt.Sprintf("adb request (%s) failed: %s", e.Request, e.Message)
+}
+
+// conn is a single connection to the adb server, every host request needs a new connection.
+type conn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (client Client) dial() (*conn, error) {
+	c, err := net.DialTimeout("tcp", client.Address, client.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to adb server (%s), error: %s", client.Address, err)
+	}
+	return &conn{Conn: c, timeout: client.Timeout}, nil
+}
+
+func (c *conn) refreshDeadline() error {
+	return c.SetDeadline(time.Now().Add(c.timeout))
+}
+
+// request sends a length prefixed request and reads the OKAY/FAIL status.
+func (c *conn) request(req string) error {
+	if err := c.refreshDeadline(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c, "%04x%s", len(req), req); err != nil {
+		return err
+	}
+	return c.readStatus(req)
+}
+
+func (c *conn) readStatus(req string) error {
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(c, status); err != nil {
+		return err
+	}
+
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := c.readHexLengthPrefixed()
+		if err != nil {
+			return err
+		}
+		return ServerError{Request: req, Message: message}
+	}
+	return fmt.Errorf("unexpected adb server status: %q", status)
+}
+
+func (c *conn) readHexLengthPrefixed() (string, error) {
+	lengthHex := make([]byte, 4)
+	if _, err := io.ReadFull(c, lengthHex); err != nil {
+		return "", err
+	}
+
+	length, err := strconv.ParseUint(string(lengthHex), 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid length prefix: %q", lengthHex)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// transport opens a connection switched to the given device.
+func (client Client) transport(serial string) (*conn, error) {
+	c, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.request("host:transport:" + serial); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Version returns the internal version number of the adb server.
+func (client Client) Version() (int, error) {
+	c, err := client.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if err := c.request("host:version"); err != nil {
+		return 0, err
+	}
+
+	versionHex, err := c.readHexLengthPrefixed()
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.ParseInt(versionHex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version: %s", versionHex)
+	}
+	return int(version), nil
+}
+
+// Devices lists the devices known by the adb server.
+func (client Client) Devices() ([]Device, error) {
+	c, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if err := c.request("host:devices-l"); err != nil {
+		return nil, err
+	}
+
+	out, err := c.readHexLengthPrefixed()
+	if err != nil {
+		return nil, err
+	}
+	return parseDevices(out), nil
+}
+
+// parseDevices parses lines like:
+// emulator-5554          device product:sdk_gphone_x86 model:Android_SDK_built_for_x86 device:generic_x86 transport_id:1
+// The state can contain spaces, like: no permissions (user in plugdev group; are your udev rules wrong?)
+// so it lasts until the first key:value field.
+func parseDevices(out string) []Device {
+	var devices []Device
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := Device{Serial: fields[0]}
+		var state []string
+		for _, field := range fields[1:] {
+			key, value, ok := parseDeviceField(field)
+			if !ok || len(state) == 0 {
+				state = append(state, field)
+				continue
+			}
+
+			switch key {
+			case "product":
+				device.Product = value
+			case "model":
+				device.Model = value
+			case "device":
+				device.Device = value
+			case "transport_id":
+				device.TransportID = value
+			}
+		}
+		device.State = strings.Join(state, " ")
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// parseDeviceField splits a key:value field of the devices-l response, the key is a lowercase word.
+func parseDeviceField(field string) (string, string, bool) {
+	i := strings.Index(field, ":")
+	if i < 1 {
+		return "", "", false
+	}
+	for _, r := range field[:i] {
+		if (r < 'a' || r > 'z') && r != '_' {
+			return "", "", false
+		}
+	}
+	return field[:i], field[i+1:], true
+}
+
+// DeviceState returns the state of the given device (device, offline, unauthorized...),
+// or an empty string if the adb server does not know the device.
+func (client Client) DeviceState(serial string) (string, error) {
+	devices, err := client.Devices()
+	if err != nil {
+		return "", err
+	}
+
+	for _, device := range devices {
+		if device.Serial == serial {
+			return device.State, nil
+		}
+	}
+	return "", nil
+}
+
+// shellExitStatusMarker precedes the exit status echoed after the shell command,
+// the shell service of the adb server does not report it.
+const shellExitStatusMarker = "~adb-exit-status:"
+
+// ShellError is a shell command exiting with a non-zero status.
+type ShellError struct {
+	Command    string
+	ExitStatus int
+	Output     string
+}
+
+// Error ...
+func (e ShellError) Error() string {
+	return fmt.Sprintf("shell command (%s) exited with status %d: %s", e.Command, e.ExitStatus, e.Output)
+}
+
+// Shell runs the command on the device and returns its trimmed output,
+// a non-zero exit status is returned as a ShellError.
+func (client Client) Shell(serial, cmd string) (string, error) {
+	c, err := client.transport(serial)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	// the echo goes on its own line, so a trailing comment or & of the command does not swallow it
+	if err := c.request(fmt.Sprintf("shell:%s\necho %s$?", cmd, shellExitStatusMarker)); err != nil {
+		return "", err
+	}
+
+	out, err := c.readAll()
+	if err != nil {
+		return "", err
+	}
+	return parseShellOutput(cmd, string(out))
+}
+
+// readAll reads until the device closes the connection, the deadline is refreshed as data arrives,
+// so only idle connections time out.
+func (c *conn) readAll() ([]byte, error) {
+	var out []byte
+	buf := make([]byte, 32*1024)
+	for {
+		if err := c.refreshDeadline(); err != nil {
+			return nil, err
+		}
+
+		n, err := c.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func parseShellOutput(cmd, out string) (string, error) {
+	i := strings.LastIndex(out, shellExitStatusMarker)
+	if i == -1 {
+		return "", fmt.Errorf("shell command (%s) did not report its exit status, output: %s", cmd, out)
+	}
+
+	statusStr := strings.TrimSpace(out[i+len(shellExitStatusMarker):])
+	status, err := strconv.Atoi(statusStr)
+	if err != nil {
+		return "", fmt.Errorf("shell command (%s) reported an invalid exit status: %q", cmd, statusStr)
+	}
+
+	output := strings.TrimSpace(out[:i])
+	if status != 0 {
+		return output, ShellError{Command: cmd, ExitStatus: status, Output: output}
+	}
+	return output, nil
+}
+
+// GetProp returns the value of the given system property.
+func (client Client) GetProp(serial, property string) (string, error) {
+	return client.Shell(serial, "getprop "+property)
+}
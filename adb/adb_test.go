@@ -0,0 +1,254 @@
+package adb
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is a local stand-in of the adb server, speaking the smart socket protocol:
+// every request is answered by the handler, transport requests keep the connection open for the next one.
+type fakeServer struct {
+	listener net.Listener
+	handler  func(c net.Conn, req string) bool
+}
+
+func newFakeServer(t *testing.T, handler func(c net.Conn, req string) bool) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, error: %s", err)
+	}
+
+	server := &fakeServer{listener: listener, handler: handler}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(c)
+		}
+	}()
+	return server
+}
+
+func (server *fakeServer) serve(c net.Conn) {
+	defer func() {
+		_ = c.Close()
+	}()
+
+	for {
+		lengthHex := make([]byte, 4)
+		if _, err := io.ReadFull(c, lengthHex); err != nil {
+			return
+		}
+		length, err := strconv.ParseUint(string(lengthHex), 16, 32)
+		if err != nil {
+			return
+		}
+		req := make([]byte, length)
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+
+		if !server.handler(c, string(req)) {
+			return
+		}
+	}
+}
+
+func (server *fakeServer) client(timeout time.Duration) Client {
+	return Client{Address: server.listener.Addr().String(), Timeout: timeout}
+}
+
+func (server *fakeServer) close() {
+	_ = server.listener.Close()
+}
+
+func okay(c net.Conn, data string) {
+	_, _ = fmt.Fprintf(c, "OKAY%04x%s", len(data), data)
+}
+
+func fail(c net.Conn, message string) {
+	_, _ = fmt.Fprintf(c, "FAIL%04x%s", len(message), message)
+}
+
+// shellHandler serves a single emulator-5554 device, running the shell commands with run.
+func shellHandler(run func(c net.Conn, cmd string)) func(c net.Conn, req string) bool {
+	return func(c net.Conn, req string) bool {
+		switch {
+		case req == "host:transport:emulator-5554":
+			_, _ = c.Write([]byte("OKAY"))
+			return true
+		case strings.HasPrefix(req, "host:transport:"):
+			fail(c, "device '"+strings.TrimPrefix(req, "host:transport:")+"' not found")
+		case strings.HasPrefix(req, "shell:"):
+			_, _ = c.Write([]byte("OKAY"))
+			run(c, strings.TrimPrefix(req, "shell:"))
+		default:
+			fail(c, "unknown host service")
+		}
+		return false
+	}
+}
+
+// echoStatus writes the output and the exit status echo of the command, like the device shell.
+func echoStatus(c net.Conn, output string, status int) {
+	_, _ = fmt.Fprintf(c, "%s%s%d\r\n", output, shellExitStatusMarker, status)
+}
+
+func TestVersion(t *testing.T) {
+	server := newFakeServer(t, func(c net.Conn, req string) bool {
+		if req == "host:version" {
+			okay(c, "0029")
+		}
+		return false
+	})
+	defer server.close()
+
+	version, err := server.client(time.Second).Version()
+	if err != nil {
+		t.Fatalf("Version() error: %s", err)
+	}
+	if version != 41 {
+		t.Errorf("Version() = %d, want 41", version)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	server := newFakeServer(t, func(c net.Conn, req string) bool {
+		if req == "host:devices-l" {
+			okay(c, "emulator-5554          device product:sdk_gphone_x86 model:Android_SDK_built_for_x86 device:generic_x86 transport_id:1\n"+
+				"emulator-5556          offline transport_id:2\n"+
+				"0123456789ABCDEF       no permissions (user in plugdev group; are your udev rules wrong?); see [http://developer.android.com/tools/device.html] usb:1-1 transport_id:3\n")
+		}
+		return false
+	})
+	defer server.close()
+
+	client := server.client(time.Second)
+	devices, err := client.Devices()
+	if err != nil {
+		t.Fatalf("Devices() error: %s", err)
+	}
+
+	want := []Device{
+		{Serial: "emulator-5554", State: "device", Product: "sdk_gphone_x86", Model: "Android_SDK_built_for_x86", Device: "generic_x86", TransportID: "1"},
+		{Serial: "emulator-5556", State: "offline", TransportID: "2"},
+		{Serial: "0123456789ABCDEF", State: "no permissions (user in plugdev group; are your udev rules wrong?); see [http://developer.android.com/tools/device.html]", TransportID: "3"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("Devices() = %+v, want %+v", devices, want)
+	}
+
+	for serial, wantState := range map[string]string{"emulator-5556": "offline", "emulator-5558": ""} {
+		if state, err := client.DeviceState(serial); err != nil || state != wantState {
+			t.Errorf("DeviceState(%s) = %q, %v, want %q", serial, state, err, wantState)
+		}
+	}
+}
+
+func TestServerError(t *testing.T) {
+	server := newFakeServer(t, shellHandler(nil))
+	defer server.close()
+
+	_, err := server.client(time.Second).Shell("emulator-5556", "true")
+	want := ServerError{Request: "host:transport:emulator-5556", Message: "device 'emulator-5556' not found"}
+	if err != want {
+		t.Errorf("Shell() error = %v, want %v", err, want)
+	}
+}
+
+func TestShell(t *testing.T) {
+	server := newFakeServer(t, shellHandler(func(c net.Conn, cmd string) {
+		switch {
+		case strings.HasPrefix(cmd, "getprop sys.boot_completed\n"):
+			echoStatus(c, "1\r\n", 0)
+		case strings.HasPrefix(cmd, "settings put global bad\n"):
+			echoStatus(c, "Invalid arguments\r\n", 255)
+		case strings.HasPrefix(cmd, "no-newline\n"):
+			echoStatus(c, "partial", 0)
+		case strings.HasPrefix(cmd, "cut\n"):
+			_, _ = c.Write([]byte("cut off"))
+		}
+	}))
+	defer server.close()
+
+	client := server.client(time.Second)
+
+	if out, err := client.GetProp("emulator-5554", "sys.boot_completed"); err != nil || out != "1" {
+		t.Errorf("GetProp() = %q, %v, want 1", out, err)
+	}
+	if out, err := client.Shell("emulator-5554", "no-newline"); err != nil || out != "partial" {
+		t.Errorf("Shell(no-newline) = %q, %v, want partial", out, err)
+	}
+
+	_, err := client.Shell("emulator-5554", "settings put global bad")
+	if want := (ShellError{Command: "settings put global bad", ExitStatus: 255, Output: "Invalid arguments"}); err != want {
+		t.Errorf("Shell() error = %v, want %v", err, want)
+	}
+
+	if _, err := client.Shell("emulator-5554", "cut"); err == nil {
+		t.Errorf("Shell() without exit status, want error")
+	}
+}
+
+func TestShellExitStatusEcho(t *testing.T) {
+	requests := make(chan string, 2)
+	server := newFakeServer(t, shellHandler(func(c net.Conn, cmd string) {
+		requests <- cmd
+		echoStatus(c, "", 0)
+	}))
+	defer server.close()
+
+	client := server.client(time.Second)
+	for _, cmd := range []string{"pm list packages # all of them", "sleep 1 &"} {
+		if _, err := client.Shell("emulator-5554", cmd); err != nil {
+			t.Errorf("Shell(%s) error: %s", cmd, err)
+		}
+	}
+
+	want := []string{
+		"pm list packages # all of them\necho " + shellExitStatusMarker + "$?",
+		"sleep 1 &\necho " + shellExitStatusMarker + "$?",
+	}
+	if requested := []string{<-requests, <-requests}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("shell requests = %q, want %q", requested, want)
+	}
+}
+
+func TestShellRefreshesDeadline(t *testing.T) {
+	server := newFakeServer(t, shellHandler(func(c net.Conn, cmd string) {
+		for i := 0; i < 5; i++ {
+			_, _ = fmt.Fprintf(c, "line %d\n", i)
+			time.Sleep(100 * time.Millisecond)
+		}
+		echoStatus(c, "", 0)
+	}))
+	defer server.close()
+
+	out, err := server.client(300*time.Millisecond).Shell("emulator-5554", "slow")
+	if err != nil {
+		t.Fatalf("Shell() error: %s", err)
+	}
+	if want := "line 0\nline 1\nline 2\nline 3\nline 4"; out != want {
+		t.Errorf("Shell() = %q, want %q", out, want)
+	}
+}
+
+func TestShellIdleTimeout(t *testing.T) {
+	server := newFakeServer(t, shellHandler(func(c net.Conn, cmd string) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.close()
+
+	_, err := server.client(100*time.Millisecond).Shell("emulator-5554", "hang")
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Shell() error = %v, want timeout", err)
+	}
+}
@@ -0,0 +1,238 @@
+package adb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// maxSyncChunk is the largest DATA packet the sync protocol accepts.
+const maxSyncChunk = 64 * 1024
+
+// sync opens a sync session on the given device.
+func (client Client) sync(serial string) (*conn, error) {
+	c, err := client.transport(serial)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.request("sync:"); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// writePacket writes a sync packet: 4 byte id, little endian length and the data.
+func (c *conn) writePacket(id string, data []byte) error {
+	if err := c.refreshDeadline(); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := c.Write(header); err != nil {
+		return err
+	}
+	_, err := c.Write(data)
+	return err
+}
+
+// writeHeader writes a sync packet header carrying a number instead of a length (like the DONE mtime).
+func (c *conn) writeHeader(id string, n uint32) error {
+	if err := c.refreshDeadline(); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], n)
+	_, err := c.Write(header)
+	return err
+}
+
+func (c *conn) readHeader() (string, uint32, error) {
+	if err := c.refreshDeadline(); err != nil {
+		return "", 0, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+func (c *conn) readSyncFail(req string, length uint32) error {
+	message := make([]byte, length)
+	if _, err := io.ReadFull(c, message); err != nil {
+		return err
+	}
+	return ServerError{Request: req, Message: string(message)}
+}
+
+func (c *conn) quitSync() {
+	_ = c.writeHeader("QUIT", 0)
+	_ = c.Close()
+}
+
+// RemoteFile is the STAT response of the sync protocol.
+type RemoteFile struct {
+	Mode    os.FileMode
+	Size    uint32
+	ModTime time.Time
+}
+
+// Stat returns the permissions, size and modification time of the remote file,
+// the sync protocol reports a missing file with zero values, it is returned as an os.ErrNotExist.
+func (client Client) Stat(serial, remotePth string) (RemoteFile, error) {
+	c, err := client.sync(serial)
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	defer c.quitSync()
+
+	if err := c.writePacket("STAT", []byte(remotePth)); err != nil {
+		return RemoteFile{}, err
+	}
+
+	if err := c.refreshDeadline(); err != nil {
+		return RemoteFile{}, err
+	}
+	resp := make([]byte, 16)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return RemoteFile{}, err
+	}
+	if id := string(resp[:4]); id != "STAT" {
+		return RemoteFile{}, fmt.Errorf("unexpected sync response: %q", id)
+	}
+
+	mode := binary.LittleEndian.Uint32(resp[4:])
+	if mode == 0 {
+		return RemoteFile{}, &os.PathError{Op: "stat", Path: remotePth, Err: os.ErrNotExist}
+	}
+	fileMode := os.FileMode(mode & 0777)
+	if mode&syscall.S_IFMT == syscall.S_IFDIR {
+		fileMode |= os.ModeDir
+	}
+	return RemoteFile{
+		Mode:    fileMode,
+		Size:    binary.LittleEndian.Uint32(resp[8:]),
+		ModTime: time.Unix(int64(binary.LittleEndian.Uint32(resp[12:])), 0),
+	}, nil
+}
+
+// Push writes the content of the reader into the remote file with the given permissions.
+func (client Client) Push(serial string, r io.Reader, remotePth string, mode os.FileMode, mtime time.Time) error {
+	c, err := client.sync(serial)
+	if err != nil {
+		return err
+	}
+	defer c.quitSync()
+
+	req := "SEND " + remotePth
+	if err := c.writePacket("SEND", []byte(fmt.Sprintf("%s,%d", remotePth, syscall.S_IFREG|uint32(mode.Perm())))); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxSyncChunk)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := c.writePacket("DATA", buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeHeader("DONE", uint32(mtime.Unix())); err != nil {
+		return err
+	}
+
+	id, length, err := c.readHeader()
+	if err != nil {
+		return err
+	}
+
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return c.readSyncFail(req, length)
+	}
+	return fmt.Errorf("unexpected sync response: %q", id)
+}
+
+// PushFile copies the local file to the device.
+func (client Client) PushFile(serial, localPth, remotePth string) error {
+	f, err := os.Open(localPth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return client.Push(serial, f, remotePth, info.Mode(), info.ModTime())
+}
+
+// Pull writes the content of the remote file into the writer.
+func (client Client) Pull(serial, remotePth string, w io.Writer) error {
+	c, err := client.sync(serial)
+	if err != nil {
+		return err
+	}
+	defer c.quitSync()
+
+	req := "RECV " + remotePth
+	if err := c.writePacket("RECV", []byte(remotePth)); err != nil {
+		return err
+	}
+
+	for {
+		id, length, err := c.readHeader()
+		if err != nil {
+			return err
+		}
+
+		switch id {
+		case "DATA":
+			if _, err := io.CopyN(w, c, int64(length)); err != nil {
+				return err
+			}
+		case "DONE":
+			return nil
+		case "FAIL":
+			return c.readSyncFail(req, length)
+		default:
+			return fmt.Errorf("unexpected sync response: %q", id)
+		}
+	}
+}
+
+// PullFile copies the remote file from the device into the local path.
+func (client Client) PullFile(serial, remotePth, localPth string) error {
+	f, err := os.Create(localPth)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Pull(serial, remotePth, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,214 @@
+package adb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeFile struct {
+	mode  uint32
+	data  []byte
+	mtime uint32
+}
+
+// fakeDevice serves the sync protocol of the emulator-5554 device from memory,
+// the files under /system are read-only.
+type fakeDevice struct {
+	mu    sync.Mutex
+	files map[string]fakeFile
+}
+
+func (device *fakeDevice) handler(c net.Conn, req string) bool {
+	switch req {
+	case "host:transport:emulator-5554":
+		_, _ = c.Write([]byte("OKAY"))
+		return true
+	case "sync:":
+		_, _ = c.Write([]byte("OKAY"))
+		device.serveSync(c)
+	default:
+		fail(c, "unknown host service")
+	}
+	return false
+}
+
+func writeSyncHeader(c net.Conn, id string, n uint32) {
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], n)
+	_, _ = c.Write(header)
+}
+
+func writeSyncFail(c net.Conn, message string) {
+	writeSyncHeader(c, "FAIL", uint32(len(message)))
+	_, _ = c.Write([]byte(message))
+}
+
+func readSyncPacket(c net.Conn) (string, uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return "", 0, nil, err
+	}
+
+	id, length := string(header[:4]), binary.LittleEndian.Uint32(header[4:])
+	if id == "DONE" || id == "QUIT" {
+		return id, length, nil, nil
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return "", 0, nil, err
+	}
+	return id, length, data, nil
+}
+
+func (device *fakeDevice) serveSync(c net.Conn) {
+	for {
+		id, _, data, err := readSyncPacket(c)
+		if err != nil || id == "QUIT" {
+			return
+		}
+
+		switch id {
+		case "SEND":
+			split := strings.SplitN(string(data), ",", 2)
+			mode, _ := strconv.ParseUint(split[1], 10, 32)
+			file := fakeFile{mode: uint32(mode)}
+			for {
+				id, length, data, err := readSyncPacket(c)
+				if err != nil {
+					return
+				}
+				if id == "DONE" {
+					file.mtime = length
+					break
+				}
+				file.data = append(file.data, data...)
+			}
+
+			if strings.HasPrefix(split[0], "/system/") {
+				writeSyncFail(c, "Read-only file system")
+				continue
+			}
+			device.mu.Lock()
+			device.files[split[0]] = file
+			device.mu.Unlock()
+			writeSyncHeader(c, "OKAY", 0)
+		case "RECV":
+			device.mu.Lock()
+			file, ok := device.files[string(data)]
+			device.mu.Unlock()
+			if !ok {
+				writeSyncFail(c, "No such file or directory")
+				continue
+			}
+
+			for rest := file.data; len(rest) > 0; {
+				n := len(rest)
+				if n > maxSyncChunk {
+					n = maxSyncChunk
+				}
+				writeSyncHeader(c, "DATA", uint32(n))
+				_, _ = c.Write(rest[:n])
+				rest = rest[n:]
+			}
+			writeSyncHeader(c, "DONE", 0)
+		case "STAT":
+			device.mu.Lock()
+			file := device.files[string(data)]
+			device.mu.Unlock()
+
+			resp := make([]byte, 16)
+			copy(resp, "STAT")
+			binary.LittleEndian.PutUint32(resp[4:], file.mode)
+			binary.LittleEndian.PutUint32(resp[8:], uint32(len(file.data)))
+			binary.LittleEndian.PutUint32(resp[12:], file.mtime)
+			_, _ = c.Write(resp)
+		default:
+			writeSyncFail(c, "unknown sync request: "+id)
+		}
+	}
+}
+
+func TestSyncRoundTrip(t *testing.T) {
+	device := &fakeDevice{files: map[string]fakeFile{}}
+	server := newFakeServer(t, device.handler)
+	defer server.close()
+
+	client := server.client(time.Second)
+
+	// larger than a DATA packet, so it is sent in chunks
+	content := bytes.Repeat([]byte("0123456789abcdef"), maxSyncChunk/8)
+	mtime := time.Unix(1546300800, 0)
+	if err := client.Push("emulator-5554", bytes.NewReader(content), "/data/local/tmp/test.bin", 0640, mtime); err != nil {
+		t.Fatalf("Push() error: %s", err)
+	}
+
+	info, err := client.Stat("emulator-5554", "/data/local/tmp/test.bin")
+	if err != nil {
+		t.Fatalf("Stat() error: %s", err)
+	}
+	if want := (RemoteFile{Mode: 0640, Size: uint32(len(content)), ModTime: mtime}); info != want {
+		t.Errorf("Stat() = %+v, want %+v", info, want)
+	}
+
+	var b bytes.Buffer
+	if err := client.Pull("emulator-5554", "/data/local/tmp/test.bin", &b); err != nil {
+		t.Fatalf("Pull() error: %s", err)
+	}
+	if !bytes.Equal(b.Bytes(), content) {
+		t.Errorf("Pull() = %d bytes, want the %d pushed bytes", b.Len(), len(content))
+	}
+
+	tmpDir, err := ioutil.TempDir("", "adb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	localPth := filepath.Join(tmpDir, "test.bin")
+	if err := client.PullFile("emulator-5554", "/data/local/tmp/test.bin", localPth); err != nil {
+		t.Fatalf("PullFile() error: %s", err)
+	}
+	if err := client.PushFile("emulator-5554", localPth, "/data/local/tmp/copy.bin"); err != nil {
+		t.Fatalf("PushFile() error: %s", err)
+	}
+	if copied := device.files["/data/local/tmp/copy.bin"]; !bytes.Equal(copied.data, content) {
+		t.Errorf("PushFile() pushed %d bytes, want %d", len(copied.data), len(content))
+	}
+}
+
+func TestSyncFail(t *testing.T) {
+	device := &fakeDevice{files: map[string]fakeFile{}}
+	server := newFakeServer(t, device.handler)
+	defer server.close()
+
+	client := server.client(time.Second)
+
+	err := client.Push("emulator-5554", strings.NewReader("test"), "/system/test.txt", 0644, time.Now())
+	if want := (ServerError{Request: "SEND /system/test.txt", Message: "Read-only file system"}); err != want {
+		t.Errorf("Push() error = %v, want %v", err, want)
+	}
+
+	var b bytes.Buffer
+	err = client.Pull("emulator-5554", "/data/local/tmp/missing.txt", &b)
+	if want := (ServerError{Request: "RECV /data/local/tmp/missing.txt", Message: "No such file or directory"}); err != want {
+		t.Errorf("Pull() error = %v, want %v", err, want)
+	}
+
+	if _, err := client.Stat("emulator-5554", "/data/local/tmp/missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("Stat() error = %v, want not exist", err)
+	}
+}
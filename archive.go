@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/exporter"
+)
+
+// export_avd input values
+const (
+	exportAVDNo            = "no"
+	exportAVDYes           = "yes"
+	exportAVDWithSnapshots = "with_snapshots"
+)
+
+const importReportFileName = "create-android-emulator-import-report.json"
+
+// avdArchivePath returns the path of the exported AVD archive in the deploy dir.
+func avdArchivePath(name string) string {
+	return deployPath(name + ".avd.tar.zst")
+}
+
+func exportAVD(name, androidHome, archivePth string, includeSnapshots bool) error {
+	manifest, err := avd.Export(name, androidHome, archivePth, includeSnapshots)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("System image: %s (revision: %s)", manifest.SystemImage.Path, manifest.SystemImage.Revision)
+	if manifest.EmulatorVersion != "" {
+		log.Printf("Emulator version: %s", manifest.EmulatorVersion)
+	}
+	log.Printf("Snapshots included: %t", manifest.Snapshots)
+	log.Donef("AVD archive: %s", archivePth)
+	return nil
+}
+
+// importAVD unpacks the AVD archive into the AVD home and exports the paths of the imported AVD.
+func importAVD(archivePth, androidHome string, envExporter exporter.Exporter) error {
+	runReport.startPhase("import_avd")
+	log.Printf("")
+	log.Infof("Importing AVD archive")
+
+	manifest, err := avd.Import(archivePth, androidHome, cancellation.staged)
+	if err != nil {
+		return newStepError(errAVDCreateFailed, err, "failed to import AVD from %s", archivePth)
+	}
+
+	log.Printf("System image: %s (revision: %s)", manifest.SystemImage.Path, manifest.SystemImage.Revision)
+	if manifest.EmulatorVersion != "" {
+		log.Printf("Exported with emulator version: %s", manifest.EmulatorVersion)
+		if version := avd.EmulatorVersion(androidHome); version != manifest.EmulatorVersion {
+			if manifest.Snapshots {
+				log.Warnf("The installed emulator version (%s) differs, the snapshots of the AVD might not load and the emulator cold boots", version)
+			} else {
+				log.Warnf("The installed emulator version (%s) differs from the exporting one", version)
+			}
+		}
+	}
+	log.Donef("Imported AVD: %s", avd.Dir(manifest.Name))
+
+	runReport.startPhase("export")
+	log.Printf("")
+	log.Infof("Exporting outputs")
+
+	for _, output := range [][2]string{
+		{bitriseEmulatorName, manifest.Name},
+		{bitriseEmulatorAVDHome, avd.Home()},
+		{bitriseEmulatorAVDPath, avd.Dir(manifest.Name)},
+		{bitriseEmulatorConfigPath, avd.ConfigPath(manifest.Name)},
+	} {
+		if err := envExporter.Export(output[0], output[1]); err != nil {
+			return newStepError(errExportFailed, err, "failed to export %s", output[0])
+		}
+		log.Printf("%s: %s", output[0], output[1])
+	}
+	return nil
+}
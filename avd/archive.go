@@ -0,0 +1,400 @@
+package avd
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// ManifestFileName is the first entry of the AVD archives.
+const ManifestFileName = "manifest.json"
+
+// ArchiveSystemImage describes the system image the archived AVD was created with.
+type ArchiveSystemImage struct {
+	// Path is the system image dir relative to the Android SDK, like system-images/android-28/default/x86/.
+	Path     string `json:"path"`
+	Revision string `json:"revision"`
+	ABI      string `json:"abi"`
+	Tag      string `json:"tag"`
+}
+
+// ArchiveManifest describes an AVD archive, the absolute paths are used to rewrite the AVD files on import.
+type ArchiveManifest struct {
+	Name            string             `json:"name"`
+	AVDHome         string             `json:"avd_home"`
+	AVDPath         string             `json:"avd_path"`
+	AndroidHome     string             `json:"android_home"`
+	SystemImage     ArchiveSystemImage `json:"system_image"`
+	EmulatorVersion string             `json:"emulator_version,omitempty"`
+	Snapshots       bool               `json:"snapshots"`
+}
+
+// NewArchiveManifest describes the given AVD,
+// the system image is read from the image.sysdir.1 of its config.ini.
+func NewArchiveManifest(name, androidHome string) (ArchiveManifest, error) {
+	config, err := ReadProperties(ConfigPath(name))
+	if err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to read config.ini, error: %s", err)
+	}
+
+	sysDir := config["image.sysdir.1"]
+	if sysDir == "" {
+		return ArchiveManifest{}, fmt.Errorf("no image.sysdir.1 in: %s", ConfigPath(name))
+	}
+
+	sourceProperties, err := ReadProperties(filepath.Join(androidHome, sysDir, "source.properties"))
+	if err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to read system image source.properties, error: %s", err)
+	}
+
+	manifest := ArchiveManifest{
+		Name:        name,
+		AVDHome:     Home(),
+		AVDPath:     Dir(name),
+		AndroidHome: androidHome,
+		SystemImage: ArchiveSystemImage{
+			Path:     sysDir,
+			Revision: sourceProperties["Pkg.Revision"],
+			ABI:      config["abi.type"],
+			Tag:      config["tag.id"],
+		},
+	}
+
+	manifest.EmulatorVersion = EmulatorVersion(androidHome)
+
+	return manifest, nil
+}
+
+// Export packs the <name>.ini and the <name>.avd dir into a zstd compressed tar archive with the manifest as first entry.
+// The snapshots are only packed if includeSnapshots is set, lock files are skipped.
+// The archive is compressed by the zstd command line tool.
+func Export(name, androidHome, archivePth string, includeSnapshots bool) (ArchiveManifest, error) {
+	manifest, err := NewArchiveManifest(name, androidHome)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	manifest.Snapshots = includeSnapshots
+
+	f, err := os.Create(archivePth)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+
+	err = compressArchive(f, manifest)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// do not leave a truncated archive behind
+		_ = os.Remove(archivePth)
+		return ArchiveManifest{}, err
+	}
+	return manifest, nil
+}
+
+// compressArchive writes the archive of the AVD through zstd into the file.
+func compressArchive(f *os.File, manifest ArchiveManifest) error {
+	cmd := exec.Command("zstd", "-q", "-T0", "-c")
+	cmd.Stdout = f
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start zstd, error: %s", err)
+	}
+
+	writeErr := writeArchive(tar.NewWriter(stdin), manifest)
+	if err := stdin.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd failed, output: %s, error: %s", stderr.String(), err)
+	}
+	return writeErr
+}
+
+func writeArchive(tw *tar.Writer, manifest ArchiveManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: ManifestFileName, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+
+	iniPth := IniPath(manifest.Name)
+	iniInfo, err := os.Stat(iniPth)
+	if err != nil {
+		return err
+	}
+	if err := addArchiveEntry(tw, iniPth, filepath.Base(iniPth), iniInfo); err != nil {
+		return err
+	}
+
+	avdDir := manifest.AVDPath
+	snapshotsDir := filepath.Join(avdDir, "snapshots")
+	if err := filepath.Walk(avdDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(info.Name(), ".lock") || (!manifest.Snapshots && pth == snapshotsDir) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(manifest.AVDHome, pth)
+		if err != nil {
+			return err
+		}
+		return addArchiveEntry(tw, pth, filepath.ToSlash(rel), info)
+	}); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func addArchiveEntry(tw *tar.Writer, pth, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// Import unpacks the AVD archive into the AVD home and rewrites the absolute paths for the local Android SDK and AVD home.
+// It refuses to import if the system image of the AVD is not installed in androidHome,
+// or if its revision differs and the archive contains snapshots (which only load with the same system image),
+// and if an AVD with the same name already exists.
+// The staging dir the AVD is unpacked in is reported to staged, which can be nil.
+func Import(archivePth, androidHome string, staged StagingFunc) (ArchiveManifest, error) {
+	cmd := exec.Command("zstd", "-d", "-q", "-c", archivePth)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to start zstd, error: %s", err)
+	}
+
+	manifest, readErr := readArchive(tar.NewReader(stdout), androidHome, staged)
+
+	// drain the rest of the stream, so zstd exits
+	_, _ = io.Copy(ioutil.Discard, stdout)
+	if err := cmd.Wait(); err != nil && readErr == nil {
+		readErr = fmt.Errorf("zstd failed, output: %s, error: %s", stderr.String(), err)
+	}
+
+	return manifest, readErr
+}
+
+func readArchive(tr *tar.Reader, androidHome string, staged StagingFunc) (ArchiveManifest, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to read archive, error: %s", err)
+	}
+	if header.Name != ManifestFileName {
+		return ArchiveManifest{}, fmt.Errorf("invalid AVD archive, the first entry is %s instead of %s", header.Name, ManifestFileName)
+	}
+
+	var manifest ArchiveManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to parse %s, error: %s", ManifestFileName, err)
+	}
+
+	if err := ValidateName(manifest.Name); err != nil {
+		return ArchiveManifest{}, fmt.Errorf("invalid %s, error: %s", ManifestFileName, err)
+	}
+
+	if err := checkSystemImage(manifest, androidHome); err != nil {
+		return manifest, err
+	}
+
+	if err := checkNotExists(manifest.Name); err != nil {
+		return manifest, err
+	}
+
+	// the archive is unpacked next to its final location, so the AVD never shows up half written
+	tmpDir, err := newStagingDir(manifest.Name, staged)
+	if err != nil {
+		return manifest, err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err := extract(tr, tmpDir); err != nil {
+		return manifest, err
+	}
+
+	return manifest, install(tmpDir, manifest.Name, PathRewrite{
+		OldAVDDir:      manifest.AVDPath,
+		NewAVDDir:      Dir(manifest.Name),
+		OldAVDHome:     manifest.AVDHome,
+		NewAVDHome:     Home(),
+		OldAndroidHome: manifest.AndroidHome,
+		NewAndroidHome: androidHome,
+	})
+}
+
+// checkNotExists fails if the AVD dir or the <name>.ini of the AVD exists.
+func checkNotExists(name string) error {
+	for _, pth := range []string{Dir(name), IniPath(name)} {
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return err
+		} else if exist {
+			return fmt.Errorf("AVD already exists at: %s", pth)
+		}
+	}
+	return nil
+}
+
+// StagingFunc is called with the temporary dirs an AVD or a template is assembled in, right after they are created.
+// The caller can remove them if the assembly is interrupted, the dirs are removed when it finishes.
+type StagingFunc func(dir string)
+
+func (staged StagingFunc) report(dir string) {
+	if staged != nil {
+		staged(dir)
+	}
+}
+
+// newStagingDir creates a temporary dir in the AVD home to assemble an AVD in, and reports it to staged.
+func newStagingDir(name string, staged StagingFunc) (string, error) {
+	if err := os.MkdirAll(Home(), 0755); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(Home(), ".import-"+name+"-")
+	if err != nil {
+		return "", err
+	}
+	staged.report(dir)
+	return dir, nil
+}
+
+// install rewrites the paths of the AVD assembled in the staging dir, and moves its <name>.avd dir and <name>.ini into the AVD home.
+func install(stagingDir, name string, rewrite PathRewrite) error {
+	tmpAVDDir := filepath.Join(stagingDir, name+".avd")
+	tmpIniPth := filepath.Join(stagingDir, name+".ini")
+	if err := RewritePaths(tmpAVDDir, tmpIniPth, rewrite); err != nil {
+		return fmt.Errorf("failed to rewrite AVD paths, error: %s", err)
+	}
+
+	avdDir := Dir(name)
+	if err := os.Rename(tmpAVDDir, avdDir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpIniPth, IniPath(name)); err != nil {
+		_ = os.RemoveAll(avdDir)
+		return err
+	}
+	return nil
+}
+
+func checkSystemImage(manifest ArchiveManifest, androidHome string) error {
+	sysDir := filepath.Join(androidHome, manifest.SystemImage.Path)
+	properties, err := ReadProperties(filepath.Join(sysDir, "source.properties"))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("the system image of the AVD is not installed at: %s", sysDir)
+	} else if err != nil {
+		return err
+	}
+
+	if revision := properties["Pkg.Revision"]; manifest.Snapshots && revision != manifest.SystemImage.Revision {
+		return fmt.Errorf("the snapshots of the AVD were taken with system image revision %s, but revision %s is installed at: %s", manifest.SystemImage.Revision, revision, sysDir)
+	}
+	return nil
+}
+
+// extract unpacks the tar stream into the dir, entries pointing outside of the dir are rejected.
+func extract(tr *tar.Reader, dir string) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		pth := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(pth, dir+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid archive entry: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(pth, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, pth, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported archive entry: %s", header.Name)
+		}
+	}
+}
+
+func extractFile(r io.Reader, pth string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(pth, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,121 @@
+package avd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Home returns the directory the AVDs are created in, resolved the same way as the emulator does:
+// $ANDROID_AVD_HOME, $ANDROID_SDK_HOME/.android/avd, then ~/.android/avd.
+func Home() string {
+	if dir := os.Getenv("ANDROID_AVD_HOME"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("ANDROID_SDK_HOME"); dir != "" {
+		return filepath.Join(dir, ".android", "avd")
+	}
+	return filepath.Join(pathutil.UserHomeDir(), ".android", "avd")
+}
+
+// namePattern allows the characters avdmanager accepts in AVD names, without a leading dot,
+// so a name can not point outside of the AVD home or clash with the staging dirs.
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+// ValidateName fails if the name is not a valid AVD name.
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty AVD name")
+	}
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid AVD name (%s), allowed characters: a-z A-Z 0-9 . _ - (not as the first character: .)", name)
+	}
+	return nil
+}
+
+// EmulatorVersion returns the Pkg.Revision of the emulator package installed in the Android SDK,
+// empty if it is not installed.
+func EmulatorVersion(androidHome string) string {
+	properties, err := ReadProperties(filepath.Join(androidHome, "emulator", "source.properties"))
+	if err != nil {
+		return ""
+	}
+	return properties["Pkg.Revision"]
+}
+
+// Dir returns the <name>.avd directory of the given AVD.
+func Dir(name string) string {
+	return filepath.Join(Home(), name+".avd")
+}
+
+// IniPath returns the <name>.ini file of the given AVD, which points to the AVD directory.
+func IniPath(name string) string {
+	return filepath.Join(Home(), name+".ini")
+}
+
+// ConfigPath returns the config.ini (hardware profile) of the given AVD.
+func ConfigPath(name string) string {
+	return filepath.Join(Dir(name), "config.ini")
+}
+
+// SnapshotDir returns the directory of the named snapshot of the given AVD,
+// the quickboot snapshot is called default_boot.
+func SnapshotDir(name, snapshot string) string {
+	return filepath.Join(Dir(name), "snapshots", snapshot)
+}
+
+// ReadProperties reads a key=value properties file, like an SDK package's source.properties.
+func ReadProperties(pth string) (map[string]string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	properties := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		properties[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
+	}
+	return properties, scanner.Err()
+}
+
+// List returns the names of the AVDs in the AVD home, found by their <name>.ini files.
+func List() ([]string, error) {
+	pths, err := filepath.Glob(filepath.Join(Home(), "*.ini"))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, pth := range pths {
+		names = append(names, strings.TrimSuffix(filepath.Base(pth), ".ini"))
+	}
+	return names, nil
+}
+
+// Delete removes the <name>.avd dir and the <name>.ini of the AVD.
+func Delete(name string) error {
+	for _, pth := range []string{Dir(name), IniPath(name)} {
+		if err := os.RemoveAll(pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package avd
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"test", "Pixel_3a_API_30", "nexus-5.x86", "1"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) error: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "../../x", "a/b", `a\b`, ".hidden", "with space", "a;b"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q), want error", name)
+		}
+	}
+}
+
+func TestReadArchiveRejectsInvalidName(t *testing.T) {
+	home, err := ioutil.TempDir("", "avd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(home)
+	}()
+	if err := os.Setenv("ANDROID_AVD_HOME", filepath.Join(home, "avd")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Unsetenv("ANDROID_AVD_HOME")
+	}()
+
+	for _, name := range []string{"../../x", "a/b"} {
+		b, err := json.Marshal(ArchiveManifest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(&tar.Header{Name: ManifestFileName, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = readArchive(tar.NewReader(&buf), filepath.Join(home, "sdk"), nil)
+		if err == nil || !strings.Contains(err.Error(), "invalid AVD name") {
+			t.Errorf("readArchive() with name %q error = %v, want invalid AVD name", name, err)
+		}
+	}
+
+	if entries, _ := ioutil.ReadDir(home); len(entries) != 0 {
+		t.Errorf("readArchive() wrote into %s", home)
+	}
+}
+
+func TestExportRemovesArchiveOnFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "avd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	if err := os.Setenv("ANDROID_AVD_HOME", filepath.Join(tmpDir, "avd")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Unsetenv("ANDROID_AVD_HOME")
+	}()
+
+	androidHome := filepath.Join(tmpDir, "sdk")
+	files := map[string]string{
+		IniPath("test"):    "path=" + Dir("test") + "\n",
+		ConfigPath("test"): "image.sysdir.1=system-images/android-28/default/x86/\nabi.type=x86\ntag.id=default\n",
+		filepath.Join(androidHome, "system-images", "android-28", "default", "x86", "source.properties"): "Pkg.Revision=4\n",
+		// zstd writes some output, then fails
+		filepath.Join(tmpDir, "bin", "zstd"): "#!/bin/sh\nhead -c 10\necho 'zstd: error 70 : Write error' >&2\nexit 1\n",
+	}
+	for pth, content := range files {
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pth, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := os.Getenv("PATH")
+	if err := os.Setenv("PATH", filepath.Join(tmpDir, "bin")+string(os.PathListSeparator)+path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Setenv("PATH", path)
+	}()
+
+	archivePth := filepath.Join(tmpDir, "test.avd.tar.zst")
+	if _, err := Export("test", androidHome, archivePth, false); err == nil || !strings.Contains(err.Error(), "zstd failed") {
+		t.Errorf("Export() error = %v, want the zstd failure", err)
+	}
+	if _, err := os.Stat(archivePth); !os.IsNotExist(err) {
+		t.Errorf("the truncated archive is left behind: %s", archivePth)
+	}
+}
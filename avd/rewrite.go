@@ -0,0 +1,148 @@
+package avd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// qcow2Magic starts the header of the qcow2 disk overlays, which store the absolute path of their backing file.
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// The qcow2 header limits, as enforced by qemu: the cluster size is between 512 bytes and 2 MB,
+// the backing file name is at most 1023 bytes.
+const (
+	qcow2MinClusterBits     = 9
+	qcow2MaxClusterBits     = 21
+	qcow2MaxBackingFileSize = 1023
+)
+
+// PathRewrite describes the move of an AVD to a new location and Android SDK.
+// The paths are replaced in the order of the fields, so the AVD dir goes before the AVD home.
+type PathRewrite struct {
+	OldAVDDir      string
+	NewAVDDir      string
+	OldAVDHome     string
+	NewAVDHome     string
+	OldAndroidHome string
+	NewAndroidHome string
+}
+
+func (rewrite PathRewrite) replacer() *strings.Replacer {
+	var pairs []string
+	for _, pair := range [][2]string{
+		{rewrite.OldAVDDir, rewrite.NewAVDDir},
+		{rewrite.OldAVDHome, rewrite.NewAVDHome},
+		{rewrite.OldAndroidHome, rewrite.NewAndroidHome},
+	} {
+		if pair[0] != "" && pair[0] != pair[1] {
+			pairs = append(pairs, pair[0], pair[1])
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// RewritePaths replaces the old absolute paths in the AVD's ini files (including the <name>.ini in iniPth,
+// config.ini, hardware-qemu.ini and the snapshots' hardware.ini) and in the backing file of the qcow2 disk overlays.
+func RewritePaths(avdDir, iniPth string, rewrite PathRewrite) error {
+	replacer := rewrite.replacer()
+
+	if err := rewriteFile(iniPth, replacer); err != nil {
+		return err
+	}
+
+	return filepath.Walk(avdDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		switch {
+		case strings.HasSuffix(info.Name(), ".ini"):
+			return rewriteFile(pth, replacer)
+		case strings.HasSuffix(info.Name(), ".qcow2"):
+			return rewriteQcow2BackingFile(pth, replacer)
+		}
+		return nil
+	})
+}
+
+func rewriteFile(pth string, replacer *strings.Replacer) error {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return err
+	}
+
+	rewritten := replacer.Replace(string(content))
+	if rewritten == string(content) {
+		return nil
+	}
+
+	info, err := os.Stat(pth)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pth, []byte(rewritten), info.Mode())
+}
+
+// rewriteQcow2BackingFile rewrites the backing file name stored in the qcow2 header:
+// the name is at backing_file_offset (header offset 8) with backing_file_size bytes (offset 16),
+// inside the first cluster (cluster_bits at offset 20), so a longer name fits until the end of the cluster.
+func rewriteQcow2BackingFile(pth string, replacer *strings.Replacer) error {
+	f, err := os.OpenFile(pth, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	header := make([]byte, 24)
+	if _, err := f.ReadAt(header, 0); err != nil || !bytes.Equal(header[:4], qcow2Magic) {
+		// not a qcow2 file
+		return nil
+	}
+
+	offset := binary.BigEndian.Uint64(header[8:16])
+	size := binary.BigEndian.Uint32(header[16:20])
+	clusterBits := binary.BigEndian.Uint32(header[20:24])
+	if offset == 0 || size == 0 {
+		return nil
+	}
+
+	if clusterBits < qcow2MinClusterBits || clusterBits > qcow2MaxClusterBits {
+		return fmt.Errorf("invalid qcow2 header of %s: cluster_bits (%d) should be between %d and %d", pth, clusterBits, qcow2MinClusterBits, qcow2MaxClusterBits)
+	}
+	clusterSize := uint64(1) << clusterBits
+	if size > qcow2MaxBackingFileSize || offset >= clusterSize || offset+uint64(size) > clusterSize {
+		return fmt.Errorf("invalid qcow2 header of %s: the backing file name (offset %d, size %d) should fit into the first cluster (%d bytes) and be at most %d bytes", pth, offset, size, clusterSize, qcow2MaxBackingFileSize)
+	}
+
+	name := make([]byte, size)
+	if _, err := f.ReadAt(name, int64(offset)); err != nil {
+		return err
+	}
+
+	rewritten := replacer.Replace(string(name))
+	if rewritten == string(name) {
+		return nil
+	}
+	if offset+uint64(len(rewritten)) > clusterSize {
+		return fmt.Errorf("the rewritten backing file path of %s does not fit into the qcow2 header", pth)
+	}
+
+	if _, err := f.WriteAt([]byte(rewritten), int64(offset)); err != nil {
+		return err
+	}
+
+	sizeBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBytes, uint32(len(rewritten)))
+	_, err = f.WriteAt(sizeBytes, 16)
+	return err
+}
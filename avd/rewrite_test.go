@@ -0,0 +1,99 @@
+package avd
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// qcow2Header returns a qcow2 header with the backing file name at the given offset,
+// the size field can be set to a different value than the length of the name.
+func qcow2Header(clusterBits uint32, offset uint64, size uint32, name string) []byte {
+	b := make([]byte, 4096)
+	copy(b, qcow2Magic)
+	binary.BigEndian.PutUint32(b[4:8], 3)
+	binary.BigEndian.PutUint64(b[8:16], offset)
+	binary.BigEndian.PutUint32(b[16:20], size)
+	binary.BigEndian.PutUint32(b[20:24], clusterBits)
+	if offset+uint64(len(name)) <= uint64(len(b)) {
+		copy(b[offset:], name)
+	}
+	return b
+}
+
+func TestRewriteQcow2BackingFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	oldName := "/old/sdk/system-images/android-28/default/x86/system.img"
+	newName := "/home/runner/android-sdk/system-images/android-28/default/x86/system.img"
+	replacer := strings.NewReplacer("/old/sdk", "/home/runner/android-sdk")
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "rewritten", header: qcow2Header(16, 512, uint32(len(oldName)), oldName), want: newName},
+		{name: "no backing file", header: qcow2Header(16, 0, 0, "")},
+		{name: "cluster bits too small", header: qcow2Header(8, 200, uint32(len(oldName)), oldName), wantErr: true},
+		{name: "cluster bits too large", header: qcow2Header(40, 512, uint32(len(oldName)), oldName), wantErr: true},
+		{name: "name too long", header: qcow2Header(16, 512, 4000, oldName), wantErr: true},
+		{name: "name outside of the cluster", header: qcow2Header(9, 500, uint32(len(oldName)), ""), wantErr: true},
+		{name: "huge size", header: qcow2Header(16, 512, 0xffffffff, oldName), wantErr: true},
+		{name: "rewritten name outside of the cluster", header: qcow2Header(9, 450, uint32(len(oldName)), oldName), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		pth := filepath.Join(tmpDir, "userdata-qemu.img.qcow2")
+		if err := ioutil.WriteFile(pth, tt.header, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := rewriteQcow2BackingFile(pth, replacer)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: rewriteQcow2BackingFile() error = %v, want error: %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(pth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := binary.BigEndian.Uint64(b[8:16])
+		size := binary.BigEndian.Uint32(b[16:20])
+		if got := string(b[offset : offset+uint64(size)]); got != tt.want {
+			t.Errorf("%s: backing file = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteQcow2IgnoresOtherFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	pth := filepath.Join(tmpDir, "cache.img.qcow2")
+	if err := ioutil.WriteFile(pth, []byte("not a qcow2 file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rewriteQcow2BackingFile(pth, strings.NewReplacer("a", "b")); err != nil {
+		t.Errorf("rewriteQcow2BackingFile() error: %s", err)
+	}
+}
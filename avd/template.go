@@ -0,0 +1,241 @@
+package avd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// TemplateManifestFileName is written last into a template dir, a template without it is incomplete.
+const TemplateManifestFileName = "template.json"
+
+// TemplateManifest describes a template AVD, the absolute paths are used to rewrite the AVD files when it is materialized.
+type TemplateManifest struct {
+	Name        string          `json:"name"`
+	AVDHome     string          `json:"avd_home"`
+	AVDPath     string          `json:"avd_path"`
+	AndroidHome string          `json:"android_home"`
+	Spec        json.RawMessage `json:"spec,omitempty"`
+}
+
+// TemplateCache stores AVDs under <Dir>/<key>, the key should be derived from everything the AVD was created from.
+type TemplateCache struct {
+	Dir string
+}
+
+// NewTemplateCache ...
+func NewTemplateCache(dir string) TemplateCache {
+	return TemplateCache{Dir: dir}
+}
+
+// TemplateDir returns the dir of the template stored with the given key.
+func (cache TemplateCache) TemplateDir(key string) string {
+	return filepath.Join(cache.Dir, key)
+}
+
+// Lookup returns the manifest of the template stored with the given key, and false if there is no complete template.
+func (cache TemplateCache) Lookup(key string) (TemplateManifest, bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cache.TemplateDir(key), TemplateManifestFileName))
+	if os.IsNotExist(err) {
+		return TemplateManifest{}, false, nil
+	} else if err != nil {
+		return TemplateManifest{}, false, err
+	}
+
+	var manifest TemplateManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return TemplateManifest{}, false, fmt.Errorf("failed to parse %s, error: %s", TemplateManifestFileName, err)
+	}
+	if err := ValidateName(manifest.Name); err != nil {
+		return TemplateManifest{}, false, fmt.Errorf("invalid %s, error: %s", TemplateManifestFileName, err)
+	}
+	return manifest, true, nil
+}
+
+// Store copies the AVD (without lock files) into the cache with the given key, spec is recorded in the template manifest.
+// The template is assembled in a temporary dir and renamed into place, an existing template is kept.
+// The temporary dirs are reported to staged, which can be nil.
+func (cache TemplateCache) Store(key, name, androidHome string, spec interface{}, staged StagingFunc) error {
+	return cache.store(key, name, androidHome, spec, staged, false)
+}
+
+// Replace stores the AVD like Store, but an existing template is swapped for the new one,
+// like a template updated with the quickboot snapshot.
+func (cache TemplateCache) Replace(key, name, androidHome string, spec interface{}, staged StagingFunc) error {
+	return cache.store(key, name, androidHome, spec, staged, true)
+}
+
+func (cache TemplateCache) store(key, name, androidHome string, spec interface{}, staged StagingFunc, replace bool) error {
+	manifest := TemplateManifest{
+		Name:        name,
+		AVDHome:     Home(),
+		AVDPath:     Dir(name),
+		AndroidHome: androidHome,
+	}
+	if spec != nil {
+		b, err := json.Marshal(spec)
+		if err != nil {
+			return err
+		}
+		manifest.Spec = b
+	}
+
+	if err := os.MkdirAll(cache.Dir, 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(cache.Dir, ".store-"+key+"-")
+	if err != nil {
+		return err
+	}
+	staged.report(tmpDir)
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err := copyAVD(Home(), tmpDir, name); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteBytesToFile(filepath.Join(tmpDir, TemplateManifestFileName), b); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	if replace {
+		// the old template is moved aside first, so the key always points to a complete template or to none
+		oldDir := tmpDir + ".old"
+		staged.report(oldDir)
+		if err := os.Rename(cache.TemplateDir(key), oldDir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		defer func() {
+			_ = os.RemoveAll(oldDir)
+		}()
+	}
+
+	if err := os.Rename(tmpDir, cache.TemplateDir(key)); err != nil {
+		if exist, existErr := pathutil.IsDirExists(cache.TemplateDir(key)); existErr == nil && exist {
+			// stored by a parallel build in the meantime
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Materialize copies the template stored with the given key into the AVD home,
+// and rewrites its absolute paths for the AVD home and the local Android SDK.
+// An existing AVD with the same name is replaced, as avdmanager create --force does.
+// The staging dir the template is copied into is reported to staged, which can be nil.
+func (cache TemplateCache) Materialize(key, androidHome string, staged StagingFunc) (TemplateManifest, error) {
+	manifest, found, err := cache.Lookup(key)
+	if err != nil {
+		return TemplateManifest{}, err
+	} else if !found {
+		return TemplateManifest{}, fmt.Errorf("no template found at: %s", cache.TemplateDir(key))
+	}
+
+	for _, pth := range []string{Dir(manifest.Name), IniPath(manifest.Name)} {
+		if err := os.RemoveAll(pth); err != nil {
+			return manifest, err
+		}
+	}
+
+	tmpDir, err := newStagingDir(manifest.Name, staged)
+	if err != nil {
+		return manifest, err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err := copyAVD(cache.TemplateDir(key), tmpDir, manifest.Name); err != nil {
+		return manifest, err
+	}
+
+	return manifest, install(tmpDir, manifest.Name, PathRewrite{
+		OldAVDDir:      manifest.AVDPath,
+		NewAVDDir:      Dir(manifest.Name),
+		OldAVDHome:     manifest.AVDHome,
+		NewAVDHome:     Home(),
+		OldAndroidHome: manifest.AndroidHome,
+		NewAndroidHome: androidHome,
+	})
+}
+
+// copyAVD copies the <name>.ini and the <name>.avd dir from srcDir into dstDir, lock files are skipped.
+func copyAVD(srcDir, dstDir, name string) error {
+	if err := copyFile(filepath.Join(srcDir, name+".ini"), filepath.Join(dstDir, name+".ini")); err != nil {
+		return err
+	}
+
+	srcAVDDir := filepath.Join(srcDir, name+".avd")
+	return filepath.Walk(srcAVDDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(info.Name(), ".lock") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcDir, pth)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dst, 0755)
+		case info.Mode().IsRegular():
+			return copyFile(pth, dst)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,84 @@
+package avd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateCacheStagingDirs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	if err := os.Setenv("ANDROID_AVD_HOME", filepath.Join(tmpDir, "avd")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Unsetenv("ANDROID_AVD_HOME")
+	}()
+
+	if err := os.MkdirAll(Dir("test"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(IniPath("test"), []byte("path="+Dir("test")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(ConfigPath("test"), []byte("hw.ramSize=1024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewTemplateCache(filepath.Join(tmpDir, "cache"))
+	// the staging dirs of a parallel build with the same key and AVD name
+	otherDirs := []string{filepath.Join(cache.Dir, ".store-key-other"), filepath.Join(Home(), ".import-test-other")}
+	for _, dir := range otherDirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var staged, all []string
+	record := func(dir string) {
+		staged = append(staged, dir)
+		all = append(all, dir)
+	}
+
+	if err := cache.Store("key", "test", filepath.Join(tmpDir, "sdk"), nil, record); err != nil {
+		t.Fatalf("Store() error: %s", err)
+	}
+	if len(staged) != 1 || !strings.HasPrefix(staged[0], filepath.Join(cache.Dir, ".store-key-")) {
+		t.Errorf("Store() staged %v, want a single .store-key- dir", staged)
+	}
+
+	staged = nil
+	if err := cache.Replace("key", "test", filepath.Join(tmpDir, "sdk"), nil, record); err != nil {
+		t.Fatalf("Replace() error: %s", err)
+	}
+	if len(staged) != 2 || staged[1] != staged[0]+".old" {
+		t.Errorf("Replace() staged %v, want the staging dir and the old template dir", staged)
+	}
+
+	staged = nil
+	if _, err := cache.Materialize("key", filepath.Join(tmpDir, "sdk"), record); err != nil {
+		t.Fatalf("Materialize() error: %s", err)
+	}
+	if len(staged) != 1 || !strings.HasPrefix(staged[0], filepath.Join(Home(), ".import-test-")) {
+		t.Errorf("Materialize() staged %v, want a single .import-test- dir", staged)
+	}
+
+	for _, dir := range otherDirs {
+		for _, pth := range all {
+			if pth == dir {
+				t.Errorf("staged the dir of another build: %s", dir)
+			}
+		}
+	}
+	if _, err := os.Stat(ConfigPath("test")); err != nil {
+		t.Errorf("materialized AVD not found: %s", err)
+	}
+}
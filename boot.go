@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/adb"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+	"github.com/bitrise-steplib/steps-create-android-emulator/logcapture"
+)
+
+const logCaptureStopTimeout = 30 * time.Second
+
+// bootPollInterval is the interval of the boot state checks, it is shortened in the tests.
+var bootPollInterval = 5 * time.Second
+
+// bootedEmulator is the emulator process started by bootEmulator.
+type bootedEmulator struct {
+	pid    int
+	exited <-chan error
+}
+
+// bootEmulator launches the AVD in the background and waits until it finishes booting.
+// The emulator output is written into a log file in the temp dir, or recorded by the log capture process if capture is set.
+// The emulator is killed if it fails to boot.
+func bootEmulator(androidHome, name string, port int, options []string, timeout time.Duration, capture *logcapture.Config) (*bootedEmulator, error) {
+	emu, err := emulator.New(androidHome)
+	if err != nil {
+		return nil, err
+	}
+
+	adbTool, err := emulator.NewADB(androidHome)
+	if err != nil {
+		return nil, err
+	}
+
+	var output *os.File
+	logPth := filepath.Join(os.TempDir(), name+"-emulator.log")
+	if capture != nil {
+		capture.ADBPath = adbTool.BinPth()
+		capture.Serial = emulator.Serial(port)
+
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate the step binary, error: %s", err)
+		}
+
+		if output, err = logcapture.Start(executable, *capture); err != nil {
+			return nil, err
+		}
+		logPth = filepath.Join(capture.Dir, logcapture.EmulatorLogName)
+	} else if output, err = os.Create(logPth); err != nil {
+		return nil, fmt.Errorf("failed to create emulator log file, error: %s", err)
+	}
+
+	cmd := emu.StartCommand(name, port, options...)
+	cmd.SetStdout(output)
+	cmd.SetStderr(output)
+	runReport.setCommand(cmd.PrintableCommandArgs())
+
+	log.Printf("")
+	log.Donef("$ %s", cmd.PrintableCommandArgs())
+	log.Printf("")
+	log.Printf("Emulator log: %s", logPth)
+
+	err = cmd.GetCmd().Start()
+	// the emulator has its own copy of the output file
+	_ = output.Close()
+	if err != nil {
+		stopLogCapture(capture)
+		return nil, fmt.Errorf("failed to start emulator, error: %s", err)
+	}
+
+	cancellation.track(cmd.GetCmd().Process.Pid)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.GetCmd().Wait()
+	}()
+
+	serial := emulator.Serial(port)
+	log.Printf("Waiting for %s to boot (timeout: %s)", serial, timeout)
+
+	if err := emulator.WaitForBoot(newDeviceClient(adbTool), serial, timeout, bootPollInterval, exited, func(state emulator.BootState) {
+		log.Printf("- %s", state)
+	}); err != nil {
+		cancellation.untrack(cmd.GetCmd().Process.Pid)
+		killEmulator(&bootedEmulator{pid: cmd.GetCmd().Process.Pid})
+		stopLogCapture(capture)
+		return nil, analyzeEmulatorLog(logPth, fmt.Errorf("%s, see the emulator log: %s", err, logPth))
+	}
+
+	// the booted emulator keeps running after the step
+	cancellation.untrack(cmd.GetCmd().Process.Pid)
+	return &bootedEmulator{pid: cmd.GetCmd().Process.Pid, exited: exited}, nil
+}
+
+// killEmulator kills the process group of the emulator.
+func killEmulator(emu *bootedEmulator) {
+	if err := syscall.Kill(-emu.pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Warnf("Failed to kill emulator, error: %s", err)
+	}
+}
+
+// stopLogCapture stops the log capture process after a failed boot, so the logs are flushed.
+func stopLogCapture(capture *logcapture.Config) {
+	if capture == nil {
+		return
+	}
+	if _, err := logcapture.Stop(capture.Dir, logCaptureStopTimeout); err != nil {
+		log.Warnf("Failed to stop log capture, error: %s", err)
+	}
+}
+
+// analyzeEmulatorLog logs the known startup failures found in the emulator log,
+// and returns the first of them wrapping the boot error, or the boot error if none is found.
+func analyzeEmulatorLog(logPth string, bootErr error) error {
+	failures, err := emulator.AnalyzeLogFile(logPth)
+	if err != nil {
+		log.Warnf("Failed to analyze emulator log, error: %s", err)
+		return bootErr
+	}
+	if len(failures) == 0 {
+		return bootErr
+	}
+
+	log.Printf("")
+	log.Errorf("Emulator startup failures:")
+	for _, failure := range failures {
+		log.Errorf("- %s: %s", failure.Kind, failure.Explanation)
+		log.Printf("  Log: %s", failure.Line)
+		log.Printf("  Fix: %s", failure.Fix)
+	}
+
+	startupErr := failures[0]
+	startupErr.Err = bootErr
+	return startupErr
+}
+
+// deviceClient is implemented by both the adb command line wrapper and the native adb client.
+type deviceClient interface {
+	emulator.DeviceQuerier
+	emulator.DeviceShell
+}
+
+// newDeviceClient starts the adb server and returns the native adb client talking to it,
+// the adb command line tool is used if the server is not reachable.
+func newDeviceClient(adbTool *emulator.ADB) deviceClient {
+	if err := adbTool.StartServer(); err != nil {
+		log.Warnf("%s", err)
+		return adbTool
+	}
+
+	client := adb.New()
+	if _, err := client.Version(); err != nil {
+		log.Warnf("Failed to connect to the adb server, falling back to the adb command, error: %s", err)
+		return adbTool
+	}
+	return client
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+)
+
+// fakeEmulatorScript records its arguments and marks the device booted, then keeps running like the emulator,
+// or prints the unknown AVD failure and exits if the AVD is called missing.
+const fakeEmulatorScript = `#!/bin/sh
+echo "$@" > "$FAKE_SDK_STATE/emulator.args"
+if [ "$2" = "missing" ]; then
+  echo "PANIC: Unknown AVD name [missing], use -list-avds to see valid list."
+  exit 1
+fi
+touch "$FAKE_SDK_STATE/booted"
+exec sleep 30
+`
+
+// fakeADBScript has no server (so the adb command is used), and lists the emulator once it booted.
+const fakeADBScript = `#!/bin/sh
+echo "$@" >> "$FAKE_SDK_STATE/adb.calls"
+case "$1" in
+  start-server)
+    echo "cannot start the server"
+    exit 1
+    ;;
+  devices)
+    echo "List of devices attached"
+    if [ -f "$FAKE_SDK_STATE/booted" ]; then
+      printf "emulator-5580\tdevice\n"
+    fi
+    ;;
+  -s)
+    echo 1
+    ;;
+esac
+`
+
+// brokenEmulatorScript fails if the legacy SDK Tools emulator is picked instead of the emulator package.
+const brokenEmulatorScript = `#!/bin/sh
+echo "legacy emulator started"
+exit 1
+`
+
+func writeScript(t *testing.T, pth, content string) {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pth, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupFakeSDK creates an Android SDK with the fake emulator (and the broken legacy one) and the fake adb,
+// adb is put on the PATH instead of the platform-tools if adbOnPath is set.
+func setupFakeSDK(t *testing.T, adbOnPath bool) (string, string, func()) {
+	tmpDir, err := ioutil.TempDir("", "boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	androidHome := filepath.Join(tmpDir, "sdk")
+	state := filepath.Join(tmpDir, "state")
+	if err := os.MkdirAll(state, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeScript(t, filepath.Join(androidHome, "emulator", "emulator"), fakeEmulatorScript)
+	writeScript(t, filepath.Join(androidHome, "tools", "emulator"), brokenEmulatorScript)
+
+	path := os.Getenv("PATH")
+	if adbOnPath {
+		writeScript(t, filepath.Join(tmpDir, "bin", "adb"), fakeADBScript)
+		path = filepath.Join(tmpDir, "bin") + string(os.PathListSeparator) + path
+	} else {
+		writeScript(t, filepath.Join(androidHome, "platform-tools", "adb"), fakeADBScript)
+	}
+
+	restores := []func(){
+		setenv("FAKE_SDK_STATE", state),
+		setenv("PATH", path),
+		setenv("TMPDIR", tmpDir),
+	}
+	interval := bootPollInterval
+	bootPollInterval = 50 * time.Millisecond
+
+	return androidHome, state, func() {
+		bootPollInterval = interval
+		for _, restore := range restores {
+			restore()
+		}
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+func TestBootEmulator(t *testing.T) {
+	for _, adbOnPath := range []bool{false, true} {
+		androidHome, state, cleanup := setupFakeSDK(t, adbOnPath)
+
+		booted, err := bootEmulator(androidHome, "test", 5580, []string{"-no-window"}, 10*time.Second, nil)
+		if err != nil {
+			t.Errorf("adb on PATH: %v: bootEmulator() error: %s", adbOnPath, err)
+			cleanup()
+			continue
+		}
+		killEmulator(booted)
+		<-booted.exited
+
+		args, err := ioutil.ReadFile(filepath.Join(state, "emulator.args"))
+		if err != nil {
+			t.Errorf("adb on PATH: %v: emulator not started: %s", adbOnPath, err)
+		} else if got := strings.TrimSpace(string(args)); got != "-avd test -port 5580 -no-window" {
+			t.Errorf("adb on PATH: %v: emulator args = %q, want the AVD, the port and the options", adbOnPath, got)
+		}
+
+		calls, err := ioutil.ReadFile(filepath.Join(state, "adb.calls"))
+		if err != nil {
+			t.Errorf("adb on PATH: %v: adb not called: %s", adbOnPath, err)
+		} else if !strings.Contains(string(calls), "-s emulator-5580 shell getprop sys.boot_completed") {
+			t.Errorf("adb on PATH: %v: the boot was not checked with the adb command, calls:\n%s", adbOnPath, calls)
+		}
+
+		cleanup()
+	}
+}
+
+func TestBootEmulatorLaunchFailure(t *testing.T) {
+	androidHome, _, cleanup := setupFakeSDK(t, false)
+	defer cleanup()
+
+	_, err := bootEmulator(androidHome, "missing", 5580, nil, 10*time.Second, nil)
+	if err == nil {
+		t.Fatalf("bootEmulator() error = nil, want the unknown AVD failure")
+	}
+
+	startupErr, ok := err.(emulator.StartupError)
+	if !ok {
+		t.Fatalf("bootEmulator() error = %s (%T), want a startup error", err, err)
+	}
+	if startupErr.Kind != emulator.FailureUnknownAVD {
+		t.Errorf("startup error kind = %s, want %s", startupErr.Kind, emulator.FailureUnknownAVD)
+	}
+	if !strings.Contains(err.Error(), "emulator exited before the boot completed") {
+		t.Errorf("bootEmulator() error = %s, want the exit of the emulator", err)
+	}
+}
+
+func TestBootEmulatorNoEmulator(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if _, err := bootEmulator(tmpDir, "test", 5580, nil, time.Second, nil); err == nil || !strings.Contains(err.Error(), "no emulator found") {
+		t.Errorf("bootEmulator() error = %v, want no emulator found", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+const cacheKeyPrefix = "android-emulator-"
+
+// CacheModel lists the dirs worth caching between builds and the key describing their content.
+type CacheModel struct {
+	Paths []string `json:"paths"`
+	Key   string   `json:"key"`
+}
+
+// cacheComponent is a cached dir with the revision (or content digest) it is keyed by.
+type cacheComponent struct {
+	path     string
+	revision string
+}
+
+// newCacheModel collects the installed platform and system image dirs, and the AVD (its dir and <name>.ini) if includeAVD is set.
+// The key is the sha256 of the component paths and revisions, so it changes whenever a component is updated;
+// the AVD is keyed by the digest of its config.ini.
+func newCacheModel(name, androidHome string, platform sdkcomponent.Platform, systemImage sdkcomponent.SystemImage, includeAVD bool) (CacheModel, error) {
+	var components []cacheComponent
+	for _, component := range []sdkcomponent.Model{platform, systemImage} {
+		pth := filepath.Join(androidHome, component.InstallPathInAndroidHome())
+
+		properties, err := avd.ReadProperties(filepath.Join(pth, "source.properties"))
+		if err != nil {
+			return CacheModel{}, fmt.Errorf("failed to read source.properties of %s, error: %s", component.GetSDKStylePath(), err)
+		}
+		components = append(components, cacheComponent{path: pth, revision: properties["Pkg.Revision"]})
+	}
+
+	if includeAVD {
+		config, err := ioutil.ReadFile(avd.ConfigPath(name))
+		if err != nil {
+			return CacheModel{}, fmt.Errorf("failed to read config.ini, error: %s", err)
+		}
+		configDigest := fmt.Sprintf("%x", sha256.Sum256(config))
+
+		components = append(components,
+			cacheComponent{path: avd.Dir(name), revision: configDigest},
+			cacheComponent{path: avd.IniPath(name), revision: configDigest},
+		)
+	}
+
+	var paths, keyLines []string
+	for _, component := range components {
+		paths = append(paths, component.path)
+		keyLines = append(keyLines, component.path+" "+component.revision)
+	}
+
+	return CacheModel{
+		Paths: paths,
+		Key:   cacheKeyPrefix + fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(keyLines, "\n")))),
+	}, nil
+}
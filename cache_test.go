@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+func writeTestFile(t *testing.T, pth, content string) {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewCacheModel(t *testing.T) {
+	androidHome, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	platform := sdkcomponent.Platform{Version: "android-19"}
+	systemImage := sdkcomponent.SystemImage{Platform: "android-19", Tag: "default", ABI: "armeabi-v7a"}
+	systemImagePropertiesPth := filepath.Join(androidHome, systemImage.InstallPathInAndroidHome(), "source.properties")
+	writeTestFile(t, avd.IniPath("test"), "path="+avd.Dir("test")+"\n")
+	writeTestFile(t, avd.ConfigPath("test"), "hw.ramSize=1024\n")
+
+	cacheKey := func(androidHome string, includeAVD bool) string {
+		cache, err := newCacheModel("test", androidHome, platform, systemImage, includeAVD)
+		if err != nil {
+			t.Fatalf("newCacheModel() error: %s", err)
+		}
+		return cache.Key
+	}
+
+	key := cacheKey(androidHome, false)
+	if again := cacheKey(androidHome, false); again != key {
+		t.Errorf("cache key of the same revisions = %s, then %s, want a stable key", key, again)
+	}
+
+	t.Run("paths", func(t *testing.T) {
+		cache, err := newCacheModel("test", androidHome, platform, systemImage, true)
+		if err != nil {
+			t.Fatalf("newCacheModel() error: %s", err)
+		}
+		want := []string{
+			filepath.Join(androidHome, "platforms", "android-19"),
+			filepath.Join(androidHome, "system-images", "android-19", "default", "armeabi-v7a"),
+			avd.Dir("test"),
+			avd.IniPath("test"),
+		}
+		if !reflect.DeepEqual(cache.Paths, want) {
+			t.Errorf("paths = %v, want %v", cache.Paths, want)
+		}
+		if cache.Key == key {
+			t.Errorf("cache key with the AVD = the key without it")
+		}
+	})
+
+	t.Run("revision change", func(t *testing.T) {
+		writeTestFile(t, systemImagePropertiesPth, "Pkg.Revision=6\nAndroidVersion.ApiLevel=19\n")
+		defer writeTestFile(t, systemImagePropertiesPth, "Pkg.Revision=5\nAndroidVersion.ApiLevel=19\n")
+
+		if updated := cacheKey(androidHome, false); updated == key {
+			t.Errorf("cache key = %s after the system image update, want a new key", updated)
+		}
+	})
+
+	t.Run("path change", func(t *testing.T) {
+		movedHome := androidHome + "-moved"
+		if err := os.Rename(androidHome, movedHome); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = os.Rename(movedHome, androidHome)
+		}()
+
+		if moved := cacheKey(movedHome, false); moved == key {
+			t.Errorf("cache key = %s for the moved SDK, want a new key", moved)
+		}
+	})
+
+	t.Run("AVD config change", func(t *testing.T) {
+		avdKey := cacheKey(androidHome, true)
+		writeTestFile(t, avd.ConfigPath("test"), "hw.ramSize=2048\n")
+
+		if updated := cacheKey(androidHome, true); updated == avdKey {
+			t.Errorf("cache key = %s after the config.ini change, want a new key", updated)
+		}
+		if sdkKey := cacheKey(androidHome, false); sdkKey != key {
+			t.Errorf("cache key without the AVD = %s after the config.ini change, want %s", sdkKey, key)
+		}
+	})
+
+	if final := cacheKey(androidHome, false); final != key {
+		t.Errorf("cache key = %s after restoring the SDK, want %s", final, key)
+	}
+}
+
+func TestNewCacheModelMissingComponent(t *testing.T) {
+	androidHome, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	systemImage := sdkcomponent.SystemImage{Platform: "android-28", Tag: "default", ABI: "x86"}
+	if _, err := newCacheModel("test", androidHome, sdkcomponent.Platform{Version: "android-19"}, systemImage, false); err == nil {
+		t.Errorf("newCacheModel() error = nil, want the missing source.properties")
+	}
+}
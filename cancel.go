@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	// cancelGracePeriod is the time the child process group has to exit after the forwarded signal, before it is killed.
+	cancelGracePeriod = 10 * time.Second
+	// cancelExitTimeout is the time the interrupted stage has to return after the child was killed, before the step exits anyway.
+	cancelExitTimeout = 5 * time.Second
+)
+
+// canceler forwards SIGTERM and SIGINT to the process group of the running child command,
+// and removes the partial results of the interrupted stage.
+type canceler struct {
+	mu sync.Mutex
+	// pgid is the process group of the running child, 0 if none is running.
+	pgid     int
+	partials []string
+	signal   os.Signal
+}
+
+var cancellation = &canceler{}
+
+// watch handles the termination signals in the background.
+func (c *canceler) watch() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+
+		c.mu.Lock()
+		c.signal = sig
+		pgid := c.pgid
+		c.mu.Unlock()
+
+		log.Warnf("Received %s, canceling", sig)
+		if pgid == 0 {
+			// the running stage is checked for cancellation when it finishes
+			time.AfterFunc(cancelGracePeriod+cancelExitTimeout, c.exit)
+			return
+		}
+
+		log.Printf("Forwarding %s to the process group: %d", sig, pgid)
+		signalGroup(pgid, sig.(syscall.Signal))
+
+		time.Sleep(cancelGracePeriod)
+		if c.running(pgid) {
+			log.Warnf("Process group %d did not exit in %s, killing it", pgid, cancelGracePeriod)
+			signalGroup(pgid, syscall.SIGKILL)
+		}
+
+		time.Sleep(cancelExitTimeout)
+		c.exit()
+	}()
+}
+
+func signalGroup(pgid int, sig syscall.Signal) {
+	if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+		log.Warnf("Failed to signal the process group %d, error: %s", pgid, err)
+	}
+}
+
+func (c *canceler) running(pgid int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pgid == pgid
+}
+
+// exit fails the step if the interrupted stage did not return in time.
+func (c *canceler) exit() {
+	fail(c.canceledError())
+}
+
+// canceled returns if a termination signal was received.
+func (c *canceler) canceled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.signal != nil
+}
+
+// canceledError removes the partial results and returns the error the canceled step exits with.
+func (c *canceler) canceledError() *StepError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pth := range c.partials {
+		if _, err := os.Lstat(pth); err != nil {
+			continue
+		}
+		log.Printf("Removing partial result: %s", pth)
+		if err := os.RemoveAll(pth); err != nil {
+			log.Warnf("Failed to remove %s, error: %s", pth, err)
+		}
+	}
+	c.partials = nil
+
+	return newStepError(errCanceled, nil, "canceled by signal: %s", c.signal)
+}
+
+// run starts the command in its own process group and waits for it,
+// the group receives the termination signal of the step.
+func (c *canceler) run(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	c.mu.Lock()
+	if c.signal != nil {
+		c.mu.Unlock()
+		return newStepError(errCanceled, nil, "canceled by signal: %s", c.signal)
+	}
+	if err := cmd.Start(); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.pgid = cmd.Process.Pid
+	c.mu.Unlock()
+
+	err := cmd.Wait()
+	c.untrack(cmd.Process.Pid)
+	return err
+}
+
+// track makes the process group receive the termination signal of the step, until it is untracked.
+func (c *canceler) track(pgid int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pgid = pgid
+}
+
+func (c *canceler) untrack(pgid int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pgid == pgid {
+		c.pgid = 0
+	}
+}
+
+// partial registers paths written by the running stage, which are removed if the step is canceled.
+func (c *canceler) partial(pths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partials = append(c.partials, pths...)
+}
+
+// partialIfNew registers the paths which do not exist yet as partial results,
+// so the results of earlier runs are not removed on cancel.
+func (c *canceler) partialIfNew(pths ...string) {
+	for _, pth := range pths {
+		if exist, err := pathutil.IsPathExists(pth); err == nil && !exist {
+			c.partial(pth)
+		}
+	}
+}
+
+// staged registers the staging dirs reported by the avd package as partial results, it is an avd.StagingFunc.
+func (c *canceler) staged(dir string) {
+	c.partial(dir)
+}
+
+// complete marks the results of the finished stage as complete.
+func (c *canceler) complete() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partials = nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+)
+
+func TestCreateAVDRegistersNewPaths(t *testing.T) {
+	for _, existing := range []bool{false, true} {
+		androidHome, cleanup := setupPipelineTest(t)
+
+		configs := testConfigs(androidHome)
+		if existing {
+			// an AVD of an earlier run, recreated by avdmanager create avd --force
+			writeTestFile(t, avd.ConfigPath(configs.Name), "hw.ramSize=1024\n")
+			writeTestFile(t, avd.IniPath(configs.Name), "path="+avd.Dir(configs.Name)+"\n")
+		}
+
+		cancellation.complete()
+		p := newPipeline(configs, androidHome, &fakeInstaller{}, &fakeCreator{}, &fakeProfileWriter{}, &fakeExporter{})
+		if err := p.createAVD(); err != nil {
+			t.Fatalf("createAVD() error: %s", err)
+		}
+
+		var want []string
+		if !existing {
+			want = []string{avd.Dir(configs.Name), avd.IniPath(configs.Name)}
+		}
+		if !reflect.DeepEqual(cancellation.partials, want) {
+			t.Errorf("existing AVD: %v: partial results = %v, want %v", existing, cancellation.partials, want)
+		}
+
+		cancellation.complete()
+		cleanup()
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+	"github.com/bitrise-steplib/steps-create-android-emulator/exporter"
+	"github.com/bitrise-tools/go-android/sdk"
+)
+
+const cliUsage = `Usage: %[1]s [command] [flags]
+
+Without a command the step runs with the inputs read from the environment.
+The create and plan commands only run the create mode, set the mode input
+(mode=collect_logs or mode=import_avd) and run without a command for the other modes.
+
+Commands:
+  create   Create (and optionally boot) an AVD, the flags map to the step inputs
+  plan     Print the phases create would run with the same flags, without changing anything
+  list     List the AVDs of the AVD home
+  delete   Delete an AVD
+  doctor   Report the Android SDK layout, the tools, disk space and hardware acceleration
+
+Run '%[1]s [command] -h' for the flags of a command.
+`
+
+// cliCommands are the subcommands of the CLI mode, they return the exit code.
+var cliCommands = map[string]func(args []string) int{
+	"create": cliCreate,
+	"plan":   cliPlan,
+	"list":   cliList,
+	"delete": cliDelete,
+	"doctor": cliDoctor,
+}
+
+// runCLI runs the subcommand of the args and returns the exit code.
+func runCLI(args []string) int {
+	command, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, cliUsage, os.Args[0])
+		if args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+			return 0
+		}
+		return exitCodes[errInvalidInput]
+	}
+	return command(args[1:])
+}
+
+// defaultConfigs returns the configs with the default values of the step inputs, the outputs are printed to the stdout.
+func defaultConfigs() ConfigsModel {
+	configs := configsFromInputs(func(key string) string { return inputDefaults[key] })
+	configs.EnvExporter = exporter.TypeStdout
+	return configs
+}
+
+// configsFlagSet binds the flags of the create and plan commands to the configs, the flag names are the step input names.
+func configsFlagSet(name string, configs *ConfigsModel) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	flags.StringVar(&configs.Name, "name", configs.Name, "Name of the new AVD")
+	flags.StringVar(&configs.Platform, "platform", configs.Platform, "Target platform of the new AVD")
+	flags.StringVar(&configs.Abi, "abi", configs.Abi, "ABI of the system image")
+	flags.StringVar(&configs.Tag, "tag", configs.Tag, "Tag of the system image")
+	flags.StringVar(&configs.Options, "options", configs.Options, "Additional avdmanager create avd options")
+	flags.StringVar(&configs.HardwareProfilePreset, "hardware_profile_preset", configs.HardwareProfilePreset, "Hardware profile preset")
+	flags.StringVar(&configs.CustomHardwareProfileContent, "custom_hardware_profile_content", configs.CustomHardwareProfileContent, "Custom hardware profile (config.ini) content")
+	customHardwareProfileFile := flags.String("custom_hardware_profile_file", "", "File to read the custom hardware profile content from")
+	flags.StringVar(&configs.HardwareProfileStrict, "hardware_profile_strict", configs.HardwareProfileStrict, "Fail on hardware profile conflicts (yes/no)")
+	flags.StringVar(&configs.AccelerationCheck, "acceleration_check", configs.AccelerationCheck, "Hardware acceleration check (warn/fail/off)")
+	flags.StringVar(&configs.WarmUpSnapshot, "warm_up_snapshot", configs.WarmUpSnapshot, "Save a quickboot snapshot (yes/no)")
+	flags.StringVar(&configs.TemplateCacheDir, "template_cache_dir", configs.TemplateCacheDir, "AVD template cache dir")
+	flags.StringVar(&configs.ExportAVD, "export_avd", configs.ExportAVD, "Export the AVD archive (no/yes/with_snapshots)")
+	flags.StringVar(&configs.CacheIncludeAVD, "cache_include_avd", configs.CacheIncludeAVD, "Include the AVD in the cache paths (yes/no)")
+	flags.StringVar(&configs.Boot, "boot", configs.Boot, "Boot the emulator (yes/no)")
+	flags.StringVar(&configs.BootTimeout, "boot_timeout", configs.BootTimeout, "Boot timeout in seconds")
+	flags.StringVar(&configs.EmulatorOptions, "emulator_options", configs.EmulatorOptions, "Additional emulator options")
+	flags.StringVar(&configs.EmulatorPort, "emulator_port", configs.EmulatorPort, "Emulator console port or auto")
+	flags.StringVar(&configs.PrepareDevice, "prepare_device", configs.PrepareDevice, "Prepare the device for UI testing (yes/no)")
+	flags.StringVar(&configs.DeviceLocale, "device_locale", configs.DeviceLocale, "Device locale, like en-US")
+	flags.StringVar(&configs.DeviceTimezone, "device_timezone", configs.DeviceTimezone, "Device timezone, like Europe/Budapest")
+	flags.StringVar(&configs.CaptureLogs, "capture_logs", configs.CaptureLogs, "Capture logcat and the emulator output (yes/no)")
+	flags.StringVar(&configs.LogcatBuffers, "logcat_buffers", configs.LogcatBuffers, "Comma separated logcat buffers")
+	flags.StringVar(&configs.LogcatFilters, "logcat_filters", configs.LogcatFilters, "Logcat filter specs")
+	flags.StringVar(&configs.LogRotateSize, "log_rotate_size_mb", configs.LogRotateSize, "Log file rotation size in megabytes")
+	flags.StringVar(&configs.LogRotateCount, "log_rotate_count", configs.LogRotateCount, "Number of rotated log files to keep")
+	flags.StringVar(&configs.EnvExporter, "env_exporter", configs.EnvExporter, fmt.Sprintf("Exporter of the outputs %s", exporter.Types))
+	flags.StringVar(&configs.DotenvPath, "dotenv_path", configs.DotenvPath, "Dotenv file of the dotenv exporter")
+	flags.StringVar(&configs.AndroidHome, "android_home", configs.AndroidHome, "Android SDK dir")
+
+	return flags, customHardwareProfileFile
+}
+
+// parseConfigsFlags parses the args with the flag set of configsFlagSet into the configs.
+func parseConfigsFlags(flags *flag.FlagSet, customHardwareProfileFile *string, args []string, configs *ConfigsModel) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", flags.Args())
+	}
+
+	if *customHardwareProfileFile != "" {
+		content, err := ioutil.ReadFile(*customHardwareProfileFile)
+		if err != nil {
+			return fmt.Errorf("failed to read custom hardware profile file, error: %s", err)
+		}
+		configs.CustomHardwareProfileContent = string(content)
+	}
+	return nil
+}
+
+func cliCreate(args []string) int {
+	configs := defaultConfigs()
+	flags, customHardwareProfileFile := configsFlagSet("create", &configs)
+	if err := parseConfigsFlags(flags, customHardwareProfileFile, args, &configs); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		log.Errorf("%s", err)
+		return exitCodes[errInvalidInput]
+	}
+
+	runStep(configs)
+	return 0
+}
+
+func cliPlan(args []string) int {
+	configs := defaultConfigs()
+	flags, customHardwareProfileFile := configsFlagSet("plan", &configs)
+	jsonOutput := flags.Bool("json", false, "Print the plan as JSON")
+	if err := parseConfigsFlags(flags, customHardwareProfileFile, args, &configs); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		log.Errorf("%s", err)
+		return exitCodes[errInvalidInput]
+	}
+	if *jsonOutput {
+		// keep stdout clean for the JSON plan
+		log.SetOutWriter(os.Stderr)
+	}
+
+	if err := configs.validate(); err != nil {
+		log.Errorf("Issue with input: %s", err)
+		return exitCodes[errInvalidInput]
+	}
+
+	androidSdk, err := sdk.New(configs.AndroidHome)
+	if err != nil {
+		log.Errorf("Failed to create sdk, error: %s", err)
+		return exitCodes[errSDKToolMissing]
+	}
+
+	installer, creator, err := newSDKTools(androidSdk)
+	if err != nil {
+		log.Errorf("%s", err)
+		return exitCodes[errSDKToolMissing]
+	}
+
+	log.Infof("Checking installed components")
+	planned, err := newPipeline(configs, androidSdk.GetAndroidHome(), installer, creator, fileProfileWriter{}, exporter.StdoutExporter{}).plan()
+	if err != nil {
+		log.Errorf("%s", err)
+		return asStepError(err).ExitCode()
+	}
+
+	if *jsonOutput {
+		return printJSON(planned)
+	}
+
+	fmt.Println()
+	log.Infof("Plan")
+	for _, stage := range planned {
+		if !stage.Run {
+			log.Printf("- %s: skip", stage.Name)
+			continue
+		}
+		log.Donef("- %s: run", stage.Name)
+		if stage.Command != "" {
+			log.Printf("  $ %s", stage.Command)
+		}
+	}
+	return 0
+}
+
+// AVDListItemModel describes an AVD of the AVD home.
+type AVDListItemModel struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Target      string `json:"target,omitempty"`
+	SystemImage string `json:"system_image,omitempty"`
+	ABI         string `json:"abi,omitempty"`
+	Running     bool   `json:"running"`
+	Serial      string `json:"serial,omitempty"`
+}
+
+func cliList(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print the AVDs as JSON")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitCodes[errInvalidInput]
+	}
+
+	names, err := avd.List()
+	if err != nil {
+		log.Errorf("Failed to list AVDs, error: %s", err)
+		return 1
+	}
+	sort.Strings(names)
+
+	running := emulator.RunningAVDs(avd.Home())
+
+	items := []AVDListItemModel{}
+	for _, name := range names {
+		item := AVDListItemModel{Name: name, Path: avd.Dir(name)}
+
+		if ini, err := avd.ReadProperties(avd.IniPath(name)); err == nil {
+			if ini["path"] != "" {
+				item.Path = ini["path"]
+			}
+			item.Target = ini["target"]
+		}
+		if config, err := avd.ReadProperties(avd.ConfigPath(name)); err == nil {
+			item.SystemImage = config["image.sysdir.1"]
+			item.ABI = config["abi.type"]
+		}
+		if port, ok := running[name]; ok {
+			item.Running = true
+			item.Serial = emulator.Serial(port)
+		}
+
+		items = append(items, item)
+	}
+
+	if *jsonOutput {
+		return printJSON(items)
+	}
+
+	log.Infof("AVDs in %s:", avd.Home())
+	for _, item := range items {
+		state := "stopped"
+		if item.Running {
+			state = "running: " + item.Serial
+		}
+		log.Printf("- %s (%s, %s)", item.Name, item.SystemImage, state)
+		log.Printf("  %s", item.Path)
+	}
+	return 0
+}
+
+func cliDelete(args []string) int {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	name := flags.String("name", "", "Name of the AVD to delete")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitCodes[errInvalidInput]
+	}
+
+	if err := deleteAVD(*name); err != nil {
+		log.Errorf("%s", err)
+		return asStepError(err).ExitCode()
+	}
+
+	log.Donef("Deleted: %s", *name)
+	return 0
+}
+
+// deleteAVD removes the stopped AVD and its port reservation.
+func deleteAVD(name string) error {
+	if name == "" {
+		return newStepError(errInvalidInput, nil, "no name specified")
+	}
+	if err := avd.ValidateName(name); err != nil {
+		return newStepError(errInvalidInput, err, "invalid name")
+	}
+
+	if exist, err := avdExists(name); err != nil {
+		return err
+	} else if !exist {
+		return newStepError(errInvalidInput, nil, "AVD not found: %s", name)
+	}
+
+	if port, ok := emulator.RunningAVDs(avd.Home())[name]; ok {
+		return fmt.Errorf("the AVD is running as %s, stop it first with: adb -s %s emu kill", emulator.Serial(port), emulator.Serial(port))
+	}
+
+	if err := emulator.NewPortAllocator(avd.Home()).Release(name); err != nil {
+		log.Warnf("Failed to release the port reservation, error: %s", err)
+	}
+
+	if err := avd.Delete(name); err != nil {
+		return fmt.Errorf("failed to delete AVD, error: %s", err)
+	}
+	return nil
+}
+
+func avdExists(name string) (bool, error) {
+	for _, pth := range []string{avd.Dir(name), avd.IniPath(name)} {
+		if _, err := os.Stat(pth); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func cliDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	androidHome := flags.String("android_home", "", "Android SDK dir (default: $ANDROID_HOME or $ANDROID_SDK_ROOT)")
+	abi := flags.String("abi", "", "ABI of the system image to run, missing hardware acceleration is only a problem for x86 and x86_64")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return exitCodes[errInvalidInput]
+	}
+
+	report := newDoctorReport(*androidHome, *abi)
+
+	exitCode := 0
+	if len(report.Problems) > 0 {
+		exitCode = 1
+	}
+
+	if *jsonOutput {
+		if code := printJSON(report); code != 0 {
+			return code
+		}
+		return exitCode
+	}
+
+	report.print()
+	return exitCode
+}
+
+func printJSON(v interface{}) int {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Errorf("%s", err)
+		return 1
+	}
+	fmt.Println(string(b))
+	return 0
+}
@@ -0,0 +1,232 @@
+package console
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// DefaultTimeout is the read/write deadline of a single console command.
+const DefaultTimeout = 30 * time.Second
+
+// CommandError is the KO response of the console.
+type CommandError struct {
+	Command string
+	Message string
+}
+
+// Error ...
+func (e CommandError) Error() string {
+	return fmt.Sprintf("console command (%s) failed: %s", e.Command, e.Message)
+}
+
+// Client is a connection to an emulator console.
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	Timeout time.Duration
+}
+
+var authTokenPathPattern = regexp.MustCompile(`find your <auth_token> in\s+'([^']+)'`)
+
+// AuthTokenPath returns the default location of the console auth token.
+func AuthTokenPath() string {
+	return filepath.Join(pathutil.UserHomeDir(), ".emulator_console_auth_token")
+}
+
+// Dial connects to the console of the emulator running on the given port of the local host.
+func Dial(port int) (*Client, error) {
+	return DialAddress(net.JoinHostPort("localhost", strconv.Itoa(port)))
+}
+
+// DialAddress connects to the console at the given address,
+// and authenticates with the auth token if the console asks for it.
+func DialAddress(address string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{conn: conn, reader: bufio.NewReader(conn), Timeout: DefaultTimeout}
+
+	banner, err := client.readResponse("")
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to read console banner, error: %s", err)
+	}
+
+	if strings.Contains(strings.Join(banner, "\n"), "Authentication required") {
+		if err := client.authenticate(banner); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (client *Client) authenticate(banner []string) error {
+	tokenPth := AuthTokenPath()
+	if match := authTokenPathPattern.FindStringSubmatch(strings.Join(banner, "\n")); len(match) == 2 {
+		tokenPth = match[1]
+	}
+
+	token, err := ioutil.ReadFile(tokenPth)
+	if err != nil {
+		return fmt.Errorf("failed to read console auth token, error: %s", err)
+	}
+
+	if _, err := client.Command("auth " + strings.TrimSpace(string(token))); err != nil {
+		return fmt.Errorf("failed to authenticate, error: %s", err)
+	}
+	return nil
+}
+
+// Close ...
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+// Command sends the command and returns the response lines preceding the OK line.
+// A KO response is returned as a CommandError.
+// Commands with line breaks are rejected, the console would run every line as a separate command.
+func (client *Client) Command(cmd string) ([]string, error) {
+	if strings.ContainsAny(cmd, "\r\n") {
+		return nil, fmt.Errorf("invalid console command (%q), must not contain line breaks", cmd)
+	}
+	if err := client.conn.SetWriteDeadline(time.Now().Add(client.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(client.conn, "%s\r\n", cmd); err != nil {
+		return nil, err
+	}
+	return client.readResponse(cmd)
+}
+
+func (client *Client) readResponse(cmd string) ([]string, error) {
+	if err := client.conn.SetReadDeadline(time.Now().Add(client.Timeout)); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK:"):
+			return lines, nil
+		case strings.HasPrefix(line, "KO"):
+			message := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "KO"), ":"))
+			return nil, CommandError{Command: cmd, Message: message}
+		}
+		lines = append(lines, line)
+	}
+}
+
+// AVDName returns the name of the AVD running in the emulator.
+func (client *Client) AVDName() (string, error) {
+	lines, err := client.Command("avd name")
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", errors.New("empty avd name response")
+	}
+	return lines[0], nil
+}
+
+// GeoFix sets the emulated GPS location.
+func (client *Client) GeoFix(longitude, latitude float64) error {
+	_, err := client.Command(fmt.Sprintf("geo fix %s %s", formatFloat(longitude), formatFloat(latitude)))
+	return err
+}
+
+// SetBatteryCapacity sets the emulated battery level in percent.
+func (client *Client) SetBatteryCapacity(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid battery capacity (%d), must be between 0 and 100", percent)
+	}
+	_, err := client.Command(fmt.Sprintf("power capacity %d", percent))
+	return err
+}
+
+// SetBatteryStatus sets the emulated battery status: unknown, charging, discharging, not-charging or full.
+func (client *Client) SetBatteryStatus(status string) error {
+	_, err := client.Command("power status " + status)
+	return err
+}
+
+// SetACCharging connects or disconnects the emulated charger.
+func (client *Client) SetACCharging(on bool) error {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	_, err := client.Command("power ac " + state)
+	return err
+}
+
+// SetNetworkSpeed sets the emulated network speed, like gsm, edge, umts, lte or full.
+func (client *Client) SetNetworkSpeed(speed string) error {
+	_, err := client.Command("network speed " + speed)
+	return err
+}
+
+// SetNetworkDelay sets the emulated network latency, like gprs, edge, umts or none.
+func (client *Client) SetNetworkDelay(delay string) error {
+	_, err := client.Command("network delay " + delay)
+	return err
+}
+
+var phoneNumberPattern = regexp.MustCompile(`^\+?[0-9]+$`)
+
+// SendSMS delivers an SMS to the emulator from the given phone number.
+// The text must fit on a single line.
+func (client *Client) SendSMS(from, text string) error {
+	if !phoneNumberPattern.MatchString(from) {
+		return fmt.Errorf("invalid phone number (%s), expected digits with an optional leading +", from)
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return errors.New("invalid sms text, must not contain line breaks")
+	}
+	_, err := client.Command(fmt.Sprintf("sms send %s %s", from, text))
+	return err
+}
+
+// Rotate rotates the screen by 90 degrees.
+func (client *Client) Rotate() error {
+	_, err := client.Command("rotate")
+	return err
+}
+
+// SaveSnapshot saves the emulator state into the named snapshot.
+func (client *Client) SaveSnapshot(name string) error {
+	_, err := client.Command("avd snapshot save " + name)
+	return err
+}
+
+// Kill terminates the emulator, the console connection is closed by the emulator.
+func (client *Client) Kill() error {
+	if _, err := client.Command("kill"); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
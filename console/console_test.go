@@ -0,0 +1,244 @@
+package console
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const banner = "Android Console: type 'help' for a list of commands\r\nOK\r\n"
+
+// fakeConsole is a local stand-in of the emulator console: it writes the banner,
+// then answers every command line with the response of the handler.
+// The connection is closed if the handler returns "".
+type fakeConsole struct {
+	listener net.Listener
+	commands chan string
+}
+
+func newFakeConsole(t *testing.T, banner string, handler func(cmd string) string) *fakeConsole {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, error: %s", err)
+	}
+
+	console := &fakeConsole{listener: listener, commands: make(chan string, 100)}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		if _, err := conn.Write([]byte(banner)); err != nil {
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			console.commands <- cmd
+
+			response := handler(cmd)
+			if response == "" {
+				return
+			}
+			if _, err := conn.Write([]byte(response)); err != nil {
+				return
+			}
+		}
+	}()
+	return console
+}
+
+func (console *fakeConsole) dial(t *testing.T) *Client {
+	client, err := DialAddress(console.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("DialAddress() error: %s", err)
+	}
+	client.Timeout = 5 * time.Second
+	return client
+}
+
+func (console *fakeConsole) close() {
+	_ = console.listener.Close()
+}
+
+func (console *fakeConsole) received() []string {
+	var commands []string
+	for {
+		select {
+		case cmd := <-console.commands:
+			commands = append(commands, cmd)
+		default:
+			return commands
+		}
+	}
+}
+
+func okHandler(cmd string) string {
+	return "OK\r\n"
+}
+
+func TestDialAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	tokenPth := filepath.Join(dir, ".emulator_console_auth_token")
+	if err := ioutil.WriteFile(tokenPth, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	authBanner := "Android Console: Authentication required\r\n" +
+		"Android Console: type 'auth <auth_token>' to authenticate\r\n" +
+		"Android Console: you can find your <auth_token> in \r\n" +
+		"'" + tokenPth + "'\r\n" +
+		"OK\r\n"
+
+	console := newFakeConsole(t, authBanner, func(cmd string) string {
+		if cmd == "auth s3cr3t" {
+			return "Android Console: type 'help' for a list of commands\r\nOK\r\n"
+		}
+		if strings.HasPrefix(cmd, "auth ") {
+			return "KO: authentication token does not match ~/.emulator_console_auth_token\r\n"
+		}
+		return "test_avd\r\nOK\r\n"
+	})
+	defer console.close()
+
+	client := console.dial(t)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	name, err := client.AVDName()
+	if err != nil {
+		t.Fatalf("AVDName() error: %s", err)
+	}
+	if name != "test_avd" {
+		t.Errorf("AVDName() = %s, want test_avd", name)
+	}
+
+	if got, want := console.received(), []string{"auth s3cr3t", "avd name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+}
+
+func TestDialAuthenticationFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	tokenPth := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenPth, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	console := newFakeConsole(t, "Android Console: Authentication required\r\nyou can find your <auth_token> in '"+tokenPth+"'\r\nOK\r\n", func(cmd string) string {
+		return "KO: authentication token does not match\r\n"
+	})
+	defer console.close()
+
+	if _, err := DialAddress(console.listener.Addr().String()); err == nil || !strings.Contains(err.Error(), "authentication token does not match") {
+		t.Errorf("DialAddress() error = %v, want authentication error", err)
+	}
+}
+
+func TestCommandResponses(t *testing.T) {
+	console := newFakeConsole(t, banner, func(cmd string) string {
+		switch cmd {
+		case "multi":
+			return "line 1\r\nline 2\r\nOK\r\n"
+		case "ok-message":
+			return "OK: done\r\n"
+		case "ko":
+			return "KO: bad command\r\n"
+		case "ko-bare":
+			return "KO\r\n"
+		}
+		return "OK\r\n"
+	})
+	defer console.close()
+
+	client := console.dial(t)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if lines, err := client.Command("multi"); err != nil || !reflect.DeepEqual(lines, []string{"line 1", "line 2"}) {
+		t.Errorf("Command(multi) = %v, %v", lines, err)
+	}
+	if lines, err := client.Command("ok-message"); err != nil || len(lines) != 0 {
+		t.Errorf("Command(ok-message) = %v, %v", lines, err)
+	}
+	if _, err := client.Command("ko"); !reflect.DeepEqual(err, CommandError{Command: "ko", Message: "bad command"}) {
+		t.Errorf("Command(ko) error = %#v", err)
+	}
+	if _, err := client.Command("ko-bare"); !reflect.DeepEqual(err, CommandError{Command: "ko-bare", Message: ""}) {
+		t.Errorf("Command(ko-bare) error = %#v", err)
+	}
+}
+
+func TestCommandRejectsLineBreaks(t *testing.T) {
+	console := newFakeConsole(t, banner, okHandler)
+	defer console.close()
+
+	client := console.dial(t)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.SendSMS("5551234", "hello\nkill"); err == nil {
+		t.Errorf("SendSMS() with line break, want error")
+	}
+	if err := client.SendSMS("555 1234", "hello"); err == nil {
+		t.Errorf("SendSMS() with invalid phone number, want error")
+	}
+	if _, err := client.Command("avd snapshot save a\r\nkill"); err == nil {
+		t.Errorf("Command() with line break, want error")
+	}
+	if err := client.SendSMS("+15551234", "hello world"); err != nil {
+		t.Errorf("SendSMS() error: %s", err)
+	}
+
+	if got, want := console.received(), []string{"sms send +15551234 hello world"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+}
+
+func TestKill(t *testing.T) {
+	console := newFakeConsole(t, banner, func(cmd string) string {
+		return ""
+	})
+	defer console.close()
+
+	client := console.dial(t)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Kill(); err != nil {
+		t.Errorf("Kill() error: %s", err)
+	}
+}
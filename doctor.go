@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+	"github.com/bitrise-tools/go-android/sdk"
+)
+
+// minFreeDiskSpace is roughly the size of a system image and a booted AVD.
+const minFreeDiskSpace = 4 << 30
+
+// DoctorReportModel describes the Android SDK layout of the host, as the step sees it.
+type DoctorReportModel struct {
+	AndroidHome  AndroidHomeModel     `json:"android_home"`
+	Tools        []SDKToolModel       `json:"tools"`
+	Java         JavaModel            `json:"java"`
+	Platforms    []SDKPackageModel    `json:"platforms"`
+	SystemImages []SDKPackageModel    `json:"system_images"`
+	Licenses     []string             `json:"licenses"`
+	DiskSpace    []DiskSpaceModel     `json:"disk_space"`
+	Acceleration emulator.AccelReport `json:"acceleration"`
+	Problems     []string             `json:"problems"`
+	Warnings     []string             `json:"warnings"`
+}
+
+// AndroidHomeModel is the SDK dir resolution: the environment, the dir in use and its evaluated symlinks (as in sdk.New).
+type AndroidHomeModel struct {
+	AndroidHomeEnv    string `json:"android_home_env"`
+	AndroidSDKRootEnv string `json:"android_sdk_root_env"`
+	Path              string `json:"path"`
+	Source            string `json:"source"`
+	EvaluatedPath     string `json:"evaluated_path,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// SDKToolModel is an SDK tool binary the step runs.
+type SDKToolModel struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Found   bool   `json:"found"`
+	Legacy  bool   `json:"legacy,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// JavaModel is the java used by the JVM based sdkmanager and avdmanager.
+type JavaModel struct {
+	JavaHome string `json:"java_home,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Found    bool   `json:"found"`
+	Version  string `json:"version,omitempty"`
+}
+
+// SDKPackageModel is an installed SDK package.
+type SDKPackageModel struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Revision string `json:"revision,omitempty"`
+}
+
+// DiskSpaceModel is the free space of the filesystem of a dir.
+type DiskSpaceModel struct {
+	Path       string `json:"path"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// newDoctorReport inspects the SDK at androidHome, or at the one set in the environment if it is empty.
+// The missing hardware acceleration is only a problem if the ABI of the configured system image needs it.
+func newDoctorReport(androidHome, abi string) DoctorReportModel {
+	report := DoctorReportModel{}
+	report.AndroidHome = resolveAndroidHome(androidHome)
+	if report.AndroidHome.Error != "" {
+		report.problem("Android SDK: %s", report.AndroidHome.Error)
+	}
+	if home := report.AndroidHome; home.AndroidHomeEnv == "" && home.AndroidSDKRootEnv != "" {
+		report.problem("ANDROID_HOME is not set, the step does not read ANDROID_SDK_ROOT: export ANDROID_HOME=%s", home.AndroidSDKRootEnv)
+	} else if home.AndroidSDKRootEnv != "" && !sameDir(home.AndroidHomeEnv, home.AndroidSDKRootEnv) {
+		report.problem("ANDROID_HOME (%s) and ANDROID_SDK_ROOT (%s) point to different SDKs, the step uses ANDROID_HOME", home.AndroidHomeEnv, home.AndroidSDKRootEnv)
+	}
+
+	sdkRoot := report.AndroidHome.EvaluatedPath
+	if sdkRoot != "" {
+		report.Tools = findSDKTools(sdkRoot)
+		for _, tool := range report.Tools {
+			if !tool.Found {
+				report.problem("%s not found in: %s", tool.Name, sdkRoot)
+			}
+		}
+
+		report.Platforms = installedPackages(sdkRoot, "platforms", "*")
+		report.SystemImages = installedPackages(sdkRoot, "system-images", "*", "*", "*")
+		report.Licenses = licenseFiles(sdkRoot)
+		if len(report.Licenses) == 0 {
+			report.problem("no license files in %s, the sdkmanager can not install packages until the licenses are accepted", filepath.Join(sdkRoot, "licenses"))
+		}
+	}
+
+	report.Java = findJava()
+	if !report.Java.Found {
+		report.problem("java not found, the sdkmanager and avdmanager need a JDK: set JAVA_HOME or add java to the PATH")
+	}
+
+	dirs := []string{avd.Home()}
+	if sdkRoot != "" {
+		dirs = append([]string{sdkRoot}, dirs...)
+	}
+	for _, dir := range dirs {
+		space, err := diskSpace(dir)
+		if err != nil {
+			report.problem("failed to check the free disk space of %s, error: %s", dir, err)
+			continue
+		}
+		if space.FreeBytes < minFreeDiskSpace {
+			report.problem("only %s free on the filesystem of %s", humanBytes(space.FreeBytes), dir)
+		}
+		report.DiskSpace = append(report.DiskSpace, space)
+	}
+
+	var emu *emulator.Model
+	if sdkRoot != "" {
+		if model, err := emulator.New(sdkRoot); err == nil {
+			emu = model
+		}
+	}
+	report.Acceleration = emulator.NewAccelChecker(emu).Check()
+	report.checkAcceleration(abi)
+
+	return report
+}
+
+// checkAcceleration reports the unusable hardware acceleration as a problem for the x86 system images,
+// and as a warning otherwise, as the ARM images run without it (like on arm64 hosts).
+func (report *DoctorReportModel) checkAcceleration(abi string) {
+	if report.Acceleration.Usable {
+		return
+	}
+	if needsAcceleration(abi) {
+		report.problem("the %s emulator image can not be run with hardware acceleration on this host", abi)
+		return
+	}
+	report.warning("x86 emulator images can not be run with hardware acceleration on this host")
+}
+
+func (report *DoctorReportModel) problem(format string, v ...interface{}) {
+	report.Problems = append(report.Problems, fmt.Sprintf(format, v...))
+}
+
+func (report *DoctorReportModel) warning(format string, v ...interface{}) {
+	report.Warnings = append(report.Warnings, fmt.Sprintf(format, v...))
+}
+
+// resolveAndroidHome picks the SDK dir to inspect: the flag, ANDROID_HOME, or ANDROID_SDK_ROOT as the fallback.
+// The step itself only reads ANDROID_HOME.
+func resolveAndroidHome(androidHome string) AndroidHomeModel {
+	model := AndroidHomeModel{
+		AndroidHomeEnv:    os.Getenv("ANDROID_HOME"),
+		AndroidSDKRootEnv: os.Getenv("ANDROID_SDK_ROOT"),
+	}
+
+	switch {
+	case androidHome != "":
+		model.Path, model.Source = androidHome, "flag"
+	case model.AndroidHomeEnv != "":
+		model.Path, model.Source = model.AndroidHomeEnv, "ANDROID_HOME"
+	case model.AndroidSDKRootEnv != "":
+		model.Path, model.Source = model.AndroidSDKRootEnv, "ANDROID_SDK_ROOT"
+	default:
+		model.Error = "neither ANDROID_HOME nor ANDROID_SDK_ROOT is set"
+		return model
+	}
+
+	androidSdk, err := sdk.New(model.Path)
+	if err != nil {
+		model.Error = fmt.Sprintf("failed to resolve %s, error: %s", model.Path, err)
+		return model
+	}
+	model.EvaluatedPath = androidSdk.GetAndroidHome()
+	return model
+}
+
+// findSDKTools locates the tools in the same places as the sdkmanager, avdmanager and emulator packages,
+// without updating the legacy SDK Tools as avdmanager.New does.
+func findSDKTools(androidHome string) []SDKToolModel {
+	toolsVersion := packageRevision(filepath.Join(androidHome, "tools"))
+
+	var tools []SDKToolModel
+	for _, name := range []string{"sdkmanager", "avdmanager"} {
+		tool := SDKToolModel{Name: name, Path: filepath.Join(androidHome, "tools", "bin", name), Version: toolsVersion}
+		if !fileExists(tool.Path) {
+			tool.Path, tool.Legacy = filepath.Join(androidHome, "tools", "android"), true
+		}
+		tool.Found = fileExists(tool.Path)
+		if !tool.Found {
+			tool.Path, tool.Version = "", ""
+		}
+		tools = append(tools, tool)
+	}
+
+	emulatorTool := SDKToolModel{Name: "emulator"}
+	if emu, err := emulator.New(androidHome); err == nil {
+		emulatorTool.Path, emulatorTool.Found = emu.BinPth(), true
+		emulatorTool.Legacy = filepath.Base(filepath.Dir(emu.BinPth())) == "tools"
+		emulatorTool.Version = packageRevision(filepath.Dir(emu.BinPth()))
+	}
+	tools = append(tools, emulatorTool)
+
+	if adb, err := emulator.NewADB(androidHome); err == nil {
+		tools = append(tools, SDKToolModel{Name: "adb", Path: adb.BinPth(), Found: true, Version: packageRevision(filepath.Dir(adb.BinPth()))})
+	} else {
+		tools = append(tools, SDKToolModel{Name: "adb"})
+	}
+
+	return tools
+}
+
+// packageRevision returns the Pkg.Revision of the SDK package in dir, empty if it is unknown.
+func packageRevision(dir string) string {
+	properties, err := avd.ReadProperties(filepath.Join(dir, "source.properties"))
+	if err != nil {
+		return ""
+	}
+	return properties["Pkg.Revision"]
+}
+
+// installedPackages lists the package dirs matching the pattern elements under the dir of the kind,
+// named by their sdkmanager path, like system-images;android-28;google_apis;x86.
+func installedPackages(androidHome, kind string, pattern ...string) []SDKPackageModel {
+	pths, err := filepath.Glob(filepath.Join(append([]string{androidHome, kind}, pattern...)...))
+	if err != nil {
+		return nil
+	}
+	sort.Strings(pths)
+
+	packages := []SDKPackageModel{}
+	for _, pth := range pths {
+		if info, err := os.Stat(pth); err != nil || !info.IsDir() {
+			continue
+		}
+		rel, err := filepath.Rel(androidHome, pth)
+		if err != nil {
+			continue
+		}
+		packages = append(packages, SDKPackageModel{
+			Name:     strings.Join(strings.Split(rel, string(filepath.Separator)), ";"),
+			Path:     pth,
+			Revision: packageRevision(pth),
+		})
+	}
+	return packages
+}
+
+func licenseFiles(androidHome string) []string {
+	infos, err := ioutil.ReadDir(filepath.Join(androidHome, "licenses"))
+	if err != nil {
+		return []string{}
+	}
+
+	licenses := []string{}
+	for _, info := range infos {
+		if !info.IsDir() {
+			licenses = append(licenses, info.Name())
+		}
+	}
+	return licenses
+}
+
+// findJava prefers $JAVA_HOME/bin/java, like the sdkmanager and avdmanager launcher scripts.
+func findJava() JavaModel {
+	model := JavaModel{JavaHome: os.Getenv("JAVA_HOME")}
+
+	if model.JavaHome != "" {
+		model.Path = filepath.Join(model.JavaHome, "bin", "java")
+		if !fileExists(model.Path) {
+			model.Path = ""
+		}
+	}
+	if model.Path == "" {
+		if pth, err := exec.LookPath("java"); err == nil {
+			model.Path = pth
+		}
+	}
+	if model.Path == "" {
+		return model
+	}
+
+	out, err := exec.Command(model.Path, "-version").CombinedOutput()
+	if err != nil {
+		return model
+	}
+	model.Found = true
+	model.Version = strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	return model
+}
+
+func diskSpace(dir string) (DiskSpaceModel, error) {
+	// the AVD home might not exist yet, check the closest existing parent
+	pth := dir
+	for !fileExists(pth) && filepath.Dir(pth) != pth {
+		pth = filepath.Dir(pth)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(pth, &stat); err != nil {
+		return DiskSpaceModel{}, err
+	}
+	return DiskSpaceModel{
+		Path:       dir,
+		FreeBytes:  stat.Bavail * uint64(stat.Bsize),
+		TotalBytes: stat.Blocks * uint64(stat.Bsize),
+	}, nil
+}
+
+// sameDir compares the dirs with their symlinks evaluated.
+func sameDir(a, b string) bool {
+	evaluatedA, errA := filepath.EvalSymlinks(a)
+	evaluatedB, errB := filepath.EvalSymlinks(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return evaluatedA == evaluatedB
+}
+
+func fileExists(pth string) bool {
+	_, err := os.Stat(pth)
+	return err == nil
+}
+
+func humanBytes(n uint64) string {
+	return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+}
+
+// print logs the report for humans.
+func (report DoctorReportModel) print() {
+	home := report.AndroidHome
+	log.Infof("Android SDK")
+	log.Printf("- ANDROID_HOME: %s", home.AndroidHomeEnv)
+	log.Printf("- ANDROID_SDK_ROOT: %s", home.AndroidSDKRootEnv)
+	if home.EvaluatedPath != "" {
+		log.Donef("- using %s (from %s), evaluated: %s", home.Path, home.Source, home.EvaluatedPath)
+	} else {
+		log.Errorf("- %s", home.Error)
+	}
+
+	fmt.Println()
+	log.Infof("Tools")
+	for _, tool := range report.Tools {
+		if !tool.Found {
+			log.Errorf("- %s: not found", tool.Name)
+			continue
+		}
+		version := tool.Version
+		if version == "" {
+			version = "unknown version"
+		}
+		if tool.Legacy {
+			version += ", legacy"
+		}
+		log.Donef("- %s: %s (%s)", tool.Name, tool.Path, version)
+	}
+	if report.Java.Found {
+		log.Donef("- java: %s (%s)", report.Java.Path, report.Java.Version)
+	} else {
+		log.Errorf("- java: not found (JAVA_HOME: %s)", report.Java.JavaHome)
+	}
+
+	fmt.Println()
+	log.Infof("Installed packages")
+	for _, pkg := range append(report.Platforms, report.SystemImages...) {
+		log.Printf("- %s (revision: %s)", pkg.Name, pkg.Revision)
+	}
+
+	fmt.Println()
+	log.Infof("Licenses")
+	for _, license := range report.Licenses {
+		log.Printf("- %s", license)
+	}
+
+	fmt.Println()
+	log.Infof("Disk space")
+	for _, space := range report.DiskSpace {
+		log.Printf("- %s: %s free of %s", space.Path, humanBytes(space.FreeBytes), humanBytes(space.TotalBytes))
+	}
+
+	fmt.Println()
+	log.Infof("Hardware acceleration")
+	printAccelFindings(report.Acceleration)
+
+	fmt.Println()
+	if len(report.Warnings) > 0 {
+		log.Warnf("Warnings:")
+		for _, warning := range report.Warnings {
+			log.Warnf("- %s", warning)
+		}
+	}
+	if len(report.Problems) == 0 {
+		log.Donef("No problems found")
+		return
+	}
+	log.Errorf("Problems:")
+	for _, problem := range report.Problems {
+		log.Errorf("- %s", problem)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+)
+
+func TestDoctorCheckAcceleration(t *testing.T) {
+	tests := []struct {
+		name         string
+		usable       bool
+		abi          string
+		wantProblems int
+		wantWarnings int
+	}{
+		{name: "usable", usable: true, abi: "x86_64"},
+		{name: "x86 image", abi: "x86", wantProblems: 1},
+		{name: "x86_64 image", abi: "x86_64", wantProblems: 1},
+		{name: "arm image", abi: "arm64-v8a", wantWarnings: 1},
+		{name: "no image configured", wantWarnings: 1},
+	}
+
+	for _, tt := range tests {
+		report := DoctorReportModel{Acceleration: emulator.AccelReport{Usable: tt.usable}}
+		report.checkAcceleration(tt.abi)
+
+		if len(report.Problems) != tt.wantProblems {
+			t.Errorf("%s: problems = %v, want %d", tt.name, report.Problems, tt.wantProblems)
+		}
+		if len(report.Warnings) != tt.wantWarnings {
+			t.Errorf("%s: warnings = %v, want %d", tt.name, report.Warnings, tt.wantWarnings)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// access(2) modes
+const (
+	accessWrite = 0x2
+	accessRead  = 0x4
+)
+
+// FindingStatus is the result of a single acceleration check.
+type FindingStatus string
+
+// Finding statuses
+const (
+	FindingOK      FindingStatus = "ok"
+	FindingInfo    FindingStatus = "info"
+	FindingWarning FindingStatus = "warning"
+	FindingError   FindingStatus = "error"
+)
+
+// AccelFinding is the outcome of an acceleration check, with the remediation if it found a problem.
+type AccelFinding struct {
+	Check   string        `json:"check"`
+	Status  FindingStatus `json:"status"`
+	Message string        `json:"message"`
+	Fix     string        `json:"fix,omitempty"`
+}
+
+// AccelReport collects the acceleration findings of the host.
+type AccelReport struct {
+	Findings []AccelFinding `json:"findings"`
+	// Usable is false if x86 images can not be run with hardware acceleration on this host.
+	Usable bool `json:"usable"`
+}
+
+func (report *AccelReport) add(check string, status FindingStatus, message, fix string) {
+	report.Findings = append(report.Findings, AccelFinding{Check: check, Status: status, Message: message, Fix: fix})
+}
+
+// AccelChecker checks if the host can run x86 emulator images with hardware acceleration.
+// The OS and the paths are fields so the checks can run against a fake root.
+type AccelChecker struct {
+	OS           string
+	KVMPath      string
+	CPUInfoPath  string
+	SysModuleDir string
+	SysctlPath   string
+	// Emulator runs the emulator -accel-check if set.
+	Emulator *Model
+}
+
+// NewAccelChecker creates a checker of the host, emu can be nil if the emulator is not installed.
+func NewAccelChecker(emu *Model) AccelChecker {
+	return AccelChecker{
+		OS:           runtime.GOOS,
+		KVMPath:      "/dev/kvm",
+		CPUInfoPath:  "/proc/cpuinfo",
+		SysModuleDir: "/sys/module",
+		SysctlPath:   "/usr/sbin/sysctl",
+		Emulator:     emu,
+	}
+}
+
+// Check runs the checks, the KVM checks on linux and the Hypervisor.framework check on macOS.
+func (checker AccelChecker) Check() AccelReport {
+	report := AccelReport{Usable: true}
+
+	switch checker.OS {
+	case "linux":
+		checker.checkCPUFlags(&report)
+		checker.checkKVM(&report)
+	case "darwin":
+		checker.checkHypervisorFramework(&report)
+	}
+
+	if checker.Emulator != nil {
+		checker.checkEmulator(&report)
+	}
+
+	return report
+}
+
+func (checker AccelChecker) checkKVM(report *AccelReport) {
+	if _, err := os.Stat(checker.KVMPath); os.IsNotExist(err) {
+		report.Usable = false
+		report.add("kvm_device", FindingError, checker.KVMPath+" does not exist",
+			"enable virtualization (VT-x/AMD-V) in the BIOS and load the kvm module (sudo modprobe kvm_intel or kvm_amd); on a virtual machine enable nested virtualization")
+		return
+	} else if err != nil {
+		report.Usable = false
+		report.add("kvm_device", FindingError, fmt.Sprintf("failed to check %s: %s", checker.KVMPath, err), "")
+		return
+	}
+	report.add("kvm_device", FindingOK, checker.KVMPath+" exists", "")
+
+	if err := syscall.Access(checker.KVMPath, accessRead|accessWrite); err != nil {
+		report.Usable = false
+		report.add("kvm_permission", FindingError, fmt.Sprintf("%s is not readable and writable by the current user: %s", checker.KVMPath, err),
+			"add the user to the kvm group (sudo usermod -aG kvm $USER, then log in again) or grant access with a udev rule (KERNEL==\"kvm\", GROUP=\"kvm\", MODE=\"0666\")")
+		return
+	}
+	report.add("kvm_permission", FindingOK, checker.KVMPath+" is readable and writable", "")
+}
+
+// checkHypervisorFramework checks the kern.hv_support sysctl, the emulator accelerates with the Hypervisor.framework on macOS.
+func (checker AccelChecker) checkHypervisorFramework(report *AccelReport) {
+	out, err := exec.Command(checker.SysctlPath, "-n", "kern.hv_support").Output()
+	if err != nil {
+		report.add("hypervisor_framework", FindingWarning, fmt.Sprintf("failed to read kern.hv_support: %s", err), "")
+		return
+	}
+
+	if value := strings.TrimSpace(string(out)); value != "1" {
+		report.Usable = false
+		report.add("hypervisor_framework", FindingError, fmt.Sprintf("the Hypervisor.framework is not supported on this host (kern.hv_support: %s)", value),
+			"use a Mac with a CPU supporting virtualization; on a virtual machine enable nested virtualization")
+		return
+	}
+	report.add("hypervisor_framework", FindingOK, "the Hypervisor.framework is supported", "")
+}
+
+func (checker AccelChecker) checkCPUFlags(report *AccelReport) {
+	content, err := ioutil.ReadFile(checker.CPUInfoPath)
+	if err != nil {
+		report.add("cpu_flags", FindingWarning, fmt.Sprintf("failed to read %s: %s", checker.CPUInfoPath, err), "")
+		return
+	}
+
+	flags := cpuFlags(string(content))
+
+	switch {
+	case flags["vmx"]:
+		report.add("cpu_flags", FindingOK, "the CPU supports Intel VT-x (vmx)", "")
+	case flags["svm"]:
+		report.add("cpu_flags", FindingOK, "the CPU supports AMD-V (svm)", "")
+	case flags["hypervisor"]:
+		report.add("cpu_flags", FindingWarning, "running in a virtual machine without virtualization extensions exposed (no vmx or svm flag)",
+			"enable nested virtualization for this virtual machine, or use an arm image on an arm host")
+	default:
+		report.add("cpu_flags", FindingWarning, "the CPU reports no virtualization extensions (no vmx or svm flag)",
+			"enable virtualization (VT-x/AMD-V) in the BIOS")
+	}
+
+	if flags["hypervisor"] {
+		checker.checkNested(report)
+	}
+}
+
+// checkNested reports the nested virtualization setting of the loaded kvm modules,
+// it only tells if this virtual machine could host further nested guests, so it is informational.
+func (checker AccelChecker) checkNested(report *AccelReport) {
+	for _, module := range []string{"kvm_intel", "kvm_amd"} {
+		content, err := ioutil.ReadFile(filepath.Join(checker.SysModuleDir, module, "parameters", "nested"))
+		if err != nil {
+			continue
+		}
+
+		value := strings.TrimSpace(string(content))
+		enabled := value == "Y" || value == "1"
+		report.add("nested_virtualization", FindingInfo, fmt.Sprintf("running in a virtual machine, %s nested=%s (enabled: %t)", module, value, enabled), "")
+		return
+	}
+	report.add("nested_virtualization", FindingInfo, "running in a virtual machine, the emulator relies on nested virtualization provided by the hypervisor", "")
+}
+
+func (checker AccelChecker) checkEmulator(report *AccelReport) {
+	var out bytes.Buffer
+	cmd := exec.Command(checker.Emulator.BinPth(), "-accel-check")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+
+	status, message := parseAccelCheck(out.String())
+	if message == "" {
+		message = strings.TrimSpace(out.String())
+	}
+
+	if err == nil && status == "0" {
+		report.add("emulator_accel_check", FindingOK, message, "")
+		return
+	}
+
+	report.Usable = false
+	if message == "" && err != nil {
+		message = err.Error()
+	}
+	report.add("emulator_accel_check", FindingError, message, "fix the issue reported by: emulator -accel-check")
+}
+
+// parseAccelCheck parses the emulator -accel-check output:
+// the status code and the message between the accel: and accel lines.
+func parseAccelCheck(out string) (string, string) {
+	lines := strings.Split(strings.Replace(out, "\r\n", "\n", -1), "\n")
+
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "accel:" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 || start >= len(lines) {
+		return "", ""
+	}
+
+	status := strings.TrimSpace(lines[start])
+	var message []string
+	for _, line := range lines[start+1:] {
+		if strings.TrimSpace(line) == "accel" {
+			break
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			message = append(message, line)
+		}
+	}
+	return status, strings.Join(message, " ")
+}
+
+// cpuFlags returns the flags listed on the flags lines of /proc/cpuinfo.
+func cpuFlags(cpuinfo string) map[string]bool {
+	flags := map[string]bool{}
+	for _, line := range strings.Split(cpuinfo, "\n") {
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) != "flags" {
+			continue
+		}
+		for _, flag := range strings.Fields(split[1]) {
+			flags[flag] = true
+		}
+	}
+	return flags
+}
@@ -0,0 +1,197 @@
+package emulator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	intelCPUInfo   = "processor\t: 0\nflags\t\t: fpu vme de pse vmx sse sse2\n"
+	amdCPUInfo     = "processor\t: 0\nflags\t\t: fpu vme de pse svm sse sse2\n"
+	vmCPUInfo      = "processor\t: 0\nflags\t\t: fpu vme de pse sse sse2 hypervisor\n"
+	nestedCPUInfo  = "processor\t: 0\nflags\t\t: fpu vme vmx sse sse2 hypervisor\n"
+	noVirtCPUInfo  = "processor\t: 0\nflags\t\t: fpu vme de pse sse sse2\n"
+	accelCheckOK   = "accel:\n0\nKVM (version 12) is installed and usable.\naccel\n"
+	accelCheckFail = "accel:\n8\n/dev/kvm is not found: VT disabled in BIOS or KVM kernel module not loaded\naccel\n"
+)
+
+func TestAccelChecker(t *testing.T) {
+	tests := []struct {
+		name       string
+		os         string
+		kvm        os.FileMode
+		cpuinfo    string
+		nested     string
+		hvSupport  string
+		accelCheck string
+		want       [][2]string
+		wantUsable bool
+	}{
+		{
+			name:       "intel with kvm",
+			os:         "linux",
+			kvm:        0666,
+			cpuinfo:    intelCPUInfo,
+			want:       [][2]string{{"cpu_flags", "ok"}, {"kvm_device", "ok"}, {"kvm_permission", "ok"}},
+			wantUsable: true,
+		},
+		{
+			name:       "amd with kvm and a passing accel check",
+			os:         "linux",
+			kvm:        0666,
+			cpuinfo:    amdCPUInfo,
+			accelCheck: accelCheckOK,
+			want:       [][2]string{{"cpu_flags", "ok"}, {"kvm_device", "ok"}, {"kvm_permission", "ok"}, {"emulator_accel_check", "ok"}},
+			wantUsable: true,
+		},
+		{
+			name:    "no kvm device",
+			os:      "linux",
+			cpuinfo: noVirtCPUInfo,
+			want:    [][2]string{{"cpu_flags", "warning"}, {"kvm_device", "error"}},
+		},
+		{
+			name:    "virtual machine without nested virtualization",
+			os:      "linux",
+			cpuinfo: vmCPUInfo,
+			want:    [][2]string{{"cpu_flags", "warning"}, {"nested_virtualization", "info"}, {"kvm_device", "error"}},
+		},
+		{
+			name:       "virtual machine with nested virtualization",
+			os:         "linux",
+			kvm:        0666,
+			cpuinfo:    nestedCPUInfo,
+			nested:     "Y\n",
+			want:       [][2]string{{"cpu_flags", "ok"}, {"nested_virtualization", "info"}, {"kvm_device", "ok"}, {"kvm_permission", "ok"}},
+			wantUsable: true,
+		},
+		{
+			name:       "failing accel check",
+			os:         "linux",
+			kvm:        0666,
+			cpuinfo:    intelCPUInfo,
+			accelCheck: accelCheckFail,
+			want:       [][2]string{{"cpu_flags", "ok"}, {"kvm_device", "ok"}, {"kvm_permission", "ok"}, {"emulator_accel_check", "error"}},
+		},
+		{
+			name:       "macOS with the Hypervisor.framework",
+			os:         "darwin",
+			hvSupport:  "1",
+			want:       [][2]string{{"hypervisor_framework", "ok"}},
+			wantUsable: true,
+		},
+		{
+			name:      "macOS without the Hypervisor.framework",
+			os:        "darwin",
+			hvSupport: "0",
+			want:      [][2]string{{"hypervisor_framework", "error"}},
+		},
+		{
+			name:       "macOS without sysctl",
+			os:         "darwin",
+			want:       [][2]string{{"hypervisor_framework", "warning"}},
+			wantUsable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "accel")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(root)
+			}()
+
+			checker := AccelChecker{
+				OS:           tt.os,
+				KVMPath:      filepath.Join(root, "dev", "kvm"),
+				CPUInfoPath:  filepath.Join(root, "proc", "cpuinfo"),
+				SysModuleDir: filepath.Join(root, "sys", "module"),
+				SysctlPath:   filepath.Join(root, "usr", "sbin", "sysctl"),
+			}
+			if tt.kvm != 0 {
+				writeTestFile(t, checker.KVMPath, "", tt.kvm)
+			}
+			writeTestFile(t, checker.CPUInfoPath, tt.cpuinfo, 0444)
+			if tt.nested != "" {
+				writeTestFile(t, filepath.Join(checker.SysModuleDir, "kvm_intel", "parameters", "nested"), tt.nested, 0444)
+			}
+			if tt.hvSupport != "" {
+				writeTestFile(t, checker.SysctlPath, "#!/bin/sh\necho "+tt.hvSupport+"\n", 0755)
+			}
+			if tt.accelCheck != "" {
+				binPth := filepath.Join(root, "emulator", "emulator")
+				writeTestFile(t, binPth, "#!/bin/sh\nprintf '"+tt.accelCheck+"'\n", 0755)
+				checker.Emulator = &Model{binPth: binPth}
+			}
+
+			report := checker.Check()
+
+			var got [][2]string
+			for _, finding := range report.Findings {
+				got = append(got, [2]string{finding.Check, string(finding.Status)})
+				if (finding.Status == FindingError || finding.Status == FindingWarning) && finding.Message == "" {
+					t.Errorf("finding %s has no message", finding.Check)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() findings = %v, want %v", got, tt.want)
+			}
+			if report.Usable != tt.wantUsable {
+				t.Errorf("Check() usable = %v, want %v", report.Usable, tt.wantUsable)
+			}
+		})
+	}
+}
+
+func TestAccelCheckerKVMPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can access the device regardless of its permissions")
+	}
+
+	root, err := ioutil.TempDir("", "accel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(root)
+	}()
+
+	checker := AccelChecker{OS: "linux", KVMPath: filepath.Join(root, "kvm"), CPUInfoPath: filepath.Join(root, "cpuinfo")}
+	writeTestFile(t, checker.KVMPath, "", 0400)
+	writeTestFile(t, checker.CPUInfoPath, intelCPUInfo, 0444)
+
+	report := checker.Check()
+	if last := report.Findings[len(report.Findings)-1]; last.Check != "kvm_permission" || last.Status != FindingError || last.Fix == "" {
+		t.Errorf("last finding = %+v, want the kvm_permission error with its fix", last)
+	}
+	if report.Usable {
+		t.Errorf("Check() usable = true, want false")
+	}
+}
+
+func TestParseAccelCheck(t *testing.T) {
+	for out, want := range map[string][2]string{
+		accelCheckOK: {"0", "KVM (version 12) is installed and usable."},
+		"accel:\r\n0\r\nHVF\r\nis usable\r\naccel\r\n": {"0", "HVF is usable"},
+		"emulator: unknown option\n":                   {"", ""},
+	} {
+		if status, message := parseAccelCheck(out); status != want[0] || message != want[1] {
+			t.Errorf("parseAccelCheck(%q) = %q, %q, want %q, %q", out, status, message, want[0], want[1])
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, pth, content string, mode os.FileMode) {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pth, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// ADB wraps the adb command line tool.
+type ADB struct {
+	binPth string
+}
+
+// NewADB locates adb in the platform-tools of the given Android SDK, or on the PATH.
+func NewADB(androidHome string) (*ADB, error) {
+	pth := filepath.Join(androidHome, "platform-tools", "adb")
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return nil, err
+	} else if exist {
+		return &ADB{binPth: pth}, nil
+	}
+
+	pth, err := exec.LookPath("adb")
+	if err != nil {
+		return nil, fmt.Errorf("no adb found in %s/platform-tools and on the PATH", androidHome)
+	}
+	return &ADB{binPth: pth}, nil
+}
+
+// BinPth ...
+func (adb ADB) BinPth() string {
+	return adb.binPth
+}
+
+// Devices returns the state (device, offline, unauthorized...) of the connected devices by serial.
+func (adb ADB) Devices() (map[string]string, error) {
+	out, err := command.New(adb.binPth, "devices").RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("adb devices failed, output: %s, error: %s", out, err)
+	}
+
+	devices := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(scanner.Text(), "List of devices") {
+			continue
+		}
+		devices[fields[0]] = fields[1]
+	}
+	return devices, scanner.Err()
+}
+
+// DeviceState returns the state of the given device, or an empty string if adb does not list it.
+func (adb ADB) DeviceState(serial string) (string, error) {
+	devices, err := adb.Devices()
+	if err != nil {
+		return "", err
+	}
+	return devices[serial], nil
+}
+
+// StartServer starts the adb server if it is not running yet.
+func (adb ADB) StartServer() error {
+	out, err := command.New(adb.binPth, "start-server").RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb start-server failed, output: %s, error: %s", out, err)
+	}
+	return nil
+}
+
+// Shell runs the command line on the device and returns its trimmed output.
+func (adb ADB) Shell(serial, cmd string) (string, error) {
+	out, err := command.New(adb.binPth, "-s", serial, "shell", cmd).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("adb shell %s failed, output: %s, error: %s", cmd, out, err)
+	}
+	return out, nil
+}
+
+// GetProp returns the value of the given system property.
+func (adb ADB) GetProp(serial, property string) (string, error) {
+	return adb.Shell(serial, "getprop "+property)
+}
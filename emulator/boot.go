@@ -0,0 +1,77 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBootTimeout is returned if the device does not finish booting in time.
+var ErrBootTimeout = errors.New("timed out waiting for the emulator to boot")
+
+// DeviceQuerier is the part of adb needed to follow the boot of a device,
+// implemented by both the adb command line wrapper and the native adb client.
+type DeviceQuerier interface {
+	DeviceState(serial string) (string, error)
+	GetProp(serial, property string) (string, error)
+}
+
+// BootState describes how far the device got in the boot process.
+type BootState string
+
+// Boot states
+const (
+	BootStateOffline   BootState = "offline"
+	BootStateBooting   BootState = "booting"
+	BootStateCompleted BootState = "completed"
+)
+
+// CheckBootState returns offline until adb lists the device as online,
+// and completed once both sys.boot_completed and dev.bootcomplete are set.
+func CheckBootState(adb DeviceQuerier, serial string) BootState {
+	if state, err := adb.DeviceState(serial); err != nil || state != "device" {
+		return BootStateOffline
+	}
+
+	for _, property := range []string{"sys.boot_completed", "dev.bootcomplete"} {
+		if value, err := adb.GetProp(serial, property); err != nil || value != "1" {
+			return BootStateBooting
+		}
+	}
+	return BootStateCompleted
+}
+
+// WaitForBoot polls the device state until the boot completes.
+// It fails with ErrBootTimeout after the timeout, or as soon as the emulator process exits (reported on the exited channel).
+// The onStateChange callback, if set, is called every time the boot state changes.
+func WaitForBoot(adb DeviceQuerier, serial string, timeout, interval time.Duration, exited <-chan error, onStateChange func(BootState)) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastState BootState
+	for {
+		state := CheckBootState(adb, serial)
+		if state != lastState && onStateChange != nil {
+			onStateChange(state)
+		}
+		lastState = state
+
+		if state == BootStateCompleted {
+			return nil
+		}
+
+		select {
+		case err := <-exited:
+			if err == nil {
+				return errors.New("emulator exited before the boot completed")
+			}
+			return fmt.Errorf("emulator exited before the boot completed: %s", err)
+		case <-deadline.C:
+			return ErrBootTimeout
+		case <-ticker.C:
+		}
+	}
+}
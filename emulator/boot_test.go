@@ -0,0 +1,133 @@
+package emulator
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDevice scripts a boot: the state and the properties depend on the number of DeviceState polls so far.
+type fakeDevice struct {
+	mu         sync.Mutex
+	polls      int
+	state      func(poll int) string
+	properties func(poll int) map[string]string
+}
+
+func (device *fakeDevice) DeviceState(serial string) (string, error) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	device.polls++
+	state := device.state(device.polls)
+	if state == "" {
+		return "", errors.New("device not found")
+	}
+	return state, nil
+}
+
+func (device *fakeDevice) GetProp(serial, property string) (string, error) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	return device.properties(device.polls)[property], nil
+}
+
+func bootsAfter(polls int) *fakeDevice {
+	return &fakeDevice{
+		state: func(poll int) string {
+			if poll < 2 {
+				return ""
+			}
+			return "device"
+		},
+		properties: func(poll int) map[string]string {
+			if poll < polls {
+				return map[string]string{"sys.boot_completed": "", "dev.bootcomplete": ""}
+			}
+			return map[string]string{"sys.boot_completed": "1", "dev.bootcomplete": "1"}
+		},
+	}
+}
+
+func TestCheckBootState(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      string
+		properties map[string]string
+		want       BootState
+	}{
+		{name: "not listed", state: "", want: BootStateOffline},
+		{name: "offline", state: "offline", want: BootStateOffline},
+		{name: "unauthorized", state: "unauthorized", want: BootStateOffline},
+		{name: "no properties", state: "device", properties: map[string]string{}, want: BootStateBooting},
+		{name: "sys.boot_completed only", state: "device", properties: map[string]string{"sys.boot_completed": "1"}, want: BootStateBooting},
+		{name: "completed", state: "device", properties: map[string]string{"sys.boot_completed": "1", "dev.bootcomplete": "1"}, want: BootStateCompleted},
+	}
+
+	for _, tt := range tests {
+		device := &fakeDevice{
+			state:      func(int) string { return tt.state },
+			properties: func(int) map[string]string { return tt.properties },
+		}
+		if got := CheckBootState(device, "emulator-5554"); got != tt.want {
+			t.Errorf("%s: CheckBootState() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForBootCompletes(t *testing.T) {
+	var states []BootState
+	err := WaitForBoot(bootsAfter(4), "emulator-5554", time.Second, time.Millisecond, nil, func(state BootState) {
+		states = append(states, state)
+	})
+	if err != nil {
+		t.Fatalf("WaitForBoot() error: %s", err)
+	}
+
+	want := []BootState{BootStateOffline, BootStateBooting, BootStateCompleted}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+func TestWaitForBootTimeout(t *testing.T) {
+	neverCompletes := bootsAfter(1 << 30)
+	if err := WaitForBoot(neverCompletes, "emulator-5554", 20*time.Millisecond, time.Millisecond, nil, nil); err != ErrBootTimeout {
+		t.Errorf("WaitForBoot() error = %v, want %v", err, ErrBootTimeout)
+	}
+}
+
+func TestWaitForBootOffline(t *testing.T) {
+	offline := &fakeDevice{
+		state:      func(int) string { return "offline" },
+		properties: func(int) map[string]string { return nil },
+	}
+
+	var states []BootState
+	err := WaitForBoot(offline, "emulator-5554", 20*time.Millisecond, time.Millisecond, nil, func(state BootState) {
+		states = append(states, state)
+	})
+	if err != ErrBootTimeout {
+		t.Errorf("WaitForBoot() error = %v, want %v", err, ErrBootTimeout)
+	}
+	if want := []BootState{BootStateOffline}; !reflect.DeepEqual(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+func TestWaitForBootEmulatorExited(t *testing.T) {
+	for _, exitErr := range []error{nil, errors.New("exit status 1")} {
+		exited := make(chan error, 1)
+		exited <- exitErr
+
+		start := time.Now()
+		err := WaitForBoot(bootsAfter(1<<30), "emulator-5554", time.Minute, time.Millisecond, exited, nil)
+		if err == nil || err == ErrBootTimeout {
+			t.Errorf("WaitForBoot() error = %v, want emulator exited error", err)
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Errorf("WaitForBoot() did not return when the emulator exited")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package emulator
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// HeadlessOptions are the emulator flags safe to use on hosts without display, audio and GPU.
+var HeadlessOptions = []string{"-no-window", "-no-audio", "-no-boot-anim", "-gpu", "swiftshader_indirect"}
+
+// Model ...
+type Model struct {
+	binPth string
+}
+
+// New locates the emulator binary in the given Android SDK:
+// the emulator package is preferred over the legacy SDK Tools emulator.
+func New(androidHome string) (*Model, error) {
+	for _, pth := range []string{
+		filepath.Join(androidHome, "emulator", "emulator"),
+		filepath.Join(androidHome, "tools", "emulator"),
+	} {
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return nil, err
+		} else if exist {
+			return &Model{binPth: pth}, nil
+		}
+	}
+	return nil, fmt.Errorf("no emulator found in: %s", androidHome)
+}
+
+// BinPth ...
+func (model Model) BinPth() string {
+	return model.binPth
+}
+
+// Serial returns the adb serial of the emulator listening on the given console port.
+func Serial(port int) string {
+	return "emulator-" + strconv.Itoa(port)
+}
+
+// StartCommand returns the command launching the given AVD on the given console port.
+// The command is started in its own process group, so the emulator keeps running after the step exits.
+func (model Model) StartCommand(name string, port int, options ...string) *command.Model {
+	args := append([]string{"-avd", name, "-port", strconv.Itoa(port)}, options...)
+
+	cmd := exec.Command(model.binPth, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return command.NewWithCmd(cmd)
+}
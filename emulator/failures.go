@@ -0,0 +1,173 @@
+package emulator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// FailureKind identifies a known emulator startup failure.
+type FailureKind string
+
+// Failure kinds
+const (
+	FailureKVMPermission      FailureKind = "kvm_permission_denied"
+	FailureKVMMissing         FailureKind = "kvm_missing"
+	FailureMissingSystemImage FailureKind = "missing_system_image"
+	FailureUnknownAVD         FailureKind = "unknown_avd"
+	FailureEngineNotFound     FailureKind = "qemu_system_not_found"
+	FailureGPUInit            FailureKind = "gpu_init_failed"
+	FailureOutOfMemory        FailureKind = "out_of_memory"
+	FailureCorruptedSnapshot  FailureKind = "corrupted_snapshot"
+)
+
+// StartupError is an emulator startup failure recognized in the emulator output.
+type StartupError struct {
+	Kind        FailureKind
+	Explanation string
+	Fix         string
+	// Line is the emulator output line matching the failure signature.
+	Line string
+	// Err is the error the boot failed with.
+	Err error
+}
+
+// Error ...
+func (e StartupError) Error() string {
+	msg := fmt.Sprintf("%s (%s), fix: %s", e.Explanation, e.Kind, e.Fix)
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", e.Err, msg)
+	}
+	return msg
+}
+
+type failureSignature struct {
+	kind        FailureKind
+	patterns    []*regexp.Regexp
+	explanation string
+	fix         string
+}
+
+// failureSignatures are checked in order, the more specific signatures come first.
+var failureSignatures = []failureSignature{
+	{
+		kind: FailureKVMPermission,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)/dev/kvm.*permission denied`),
+			regexp.MustCompile(`(?i)doesn't have permissions to use KVM`),
+		},
+		explanation: "the emulator can not access /dev/kvm",
+		fix:         "add the user to the kvm group (sudo usermod -aG kvm $USER) or make /dev/kvm readable and writable",
+	},
+	{
+		kind: FailureKVMMissing,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)/dev/kvm is missing`),
+			regexp.MustCompile(`(?i)KVM is not installed`),
+			regexp.MustCompile(`(?i)KVM requires a CPU that supports vmx or svm`),
+		},
+		explanation: "hardware acceleration (KVM) is not available on the host",
+		fix:         "enable virtualization and load the kvm module, enable nested virtualization on virtual machines, or use an arm system image",
+	},
+	{
+		kind: FailureUnknownAVD,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)Unknown AVD name`),
+		},
+		explanation: "the emulator can not find the AVD",
+		fix:         "check that ANDROID_AVD_HOME points to the directory the AVD was created in",
+	},
+	{
+		kind: FailureEngineNotFound,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)Missing emulator engine program`),
+			regexp.MustCompile(`(?i)qemu-system-\S+: (command )?not found`),
+			regexp.MustCompile(`(?i)Could not launch .*qemu-system`),
+		},
+		explanation: "the emulator binary for the AVD's CPU architecture (qemu-system-*) is missing",
+		fix:         "install or update the emulator package (sdkmanager emulator) and start it from $ANDROID_HOME/emulator instead of $ANDROID_HOME/tools",
+	},
+	{
+		kind: FailureMissingSystemImage,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)Cannot find AVD system path`),
+			regexp.MustCompile(`(?i)configuration is missing a kernel file`),
+			regexp.MustCompile(`(?i)Could not (open|find) .*system\.img`),
+			regexp.MustCompile(`(?i)Missing initial data partition file`),
+		},
+		explanation: "the system image of the AVD is missing or incomplete",
+		fix:         "reinstall the system image with sdkmanager and check image.sysdir.1 in the AVD's config.ini",
+	},
+	{
+		kind: FailureCorruptedSnapshot,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)snapshot.*(corrupt|invalid)`),
+			regexp.MustCompile(`(?i)(failed to load|loading) snapshot.*fail`),
+			regexp.MustCompile(`(?i)Failed to load snapshot`),
+		},
+		explanation: "the AVD's snapshot can not be loaded",
+		fix:         "start the emulator with -no-snapshot-load, or delete the AVD's snapshots directory",
+	},
+	{
+		kind: FailureOutOfMemory,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)Cannot allocate memory`),
+			regexp.MustCompile(`(?i)failed to allocate .*memory`),
+			regexp.MustCompile(`(?i)out of memory`),
+			regexp.MustCompile(`(?i)Not enough (memory|space)`),
+		},
+		explanation: "the host ran out of memory or disk space while starting the emulator",
+		fix:         "lower hw.ramSize and vm.heapSize in the hardware profile, or free up memory and disk space on the host",
+	},
+	{
+		kind: FailureGPUInit,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)Could not initialize (OpenglES|OpenGL) emulation`),
+			regexp.MustCompile(`(?i)Failed to (load|initialize) .*(opengl|GLES|EGL)`),
+			regexp.MustCompile(`(?i)libGL error`),
+			regexp.MustCompile(`(?i)GPU emulation.*(fail|not supported)`),
+		},
+		explanation: "the emulator failed to initialize GPU rendering",
+		fix:         "use software rendering with -gpu swiftshader_indirect (or hw.gpu.mode=swiftshader_indirect) on hosts without a GPU",
+	},
+}
+
+// AnalyzeLog returns the known startup failures found in the emulator output,
+// in the order of the signatures, each kind at most once.
+func AnalyzeLog(output string) []StartupError {
+	lines := strings.Split(output, "\n")
+
+	var failures []StartupError
+	for _, signature := range failureSignatures {
+		if line, ok := signature.match(lines); ok {
+			failures = append(failures, StartupError{
+				Kind:        signature.kind,
+				Explanation: signature.explanation,
+				Fix:         signature.fix,
+				Line:        line,
+			})
+		}
+	}
+	return failures
+}
+
+// AnalyzeLogFile returns the known startup failures found in the emulator log file.
+func AnalyzeLogFile(pth string) ([]StartupError, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return AnalyzeLog(string(content)), nil
+}
+
+func (signature failureSignature) match(lines []string) (string, bool) {
+	for _, line := range lines {
+		for _, pattern := range signature.patterns {
+			if pattern.MatchString(line) {
+				return strings.TrimSpace(line), true
+			}
+		}
+	}
+	return "", false
+}
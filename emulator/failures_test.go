@@ -0,0 +1,69 @@
+package emulator
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeLogFile(t *testing.T) {
+	tests := []struct {
+		log      string
+		want     []FailureKind
+		wantLine string
+	}{
+		{log: "kvm_permission_denied.log", want: []FailureKind{FailureKVMPermission}, wantLine: "CPU acceleration status: This user doesn't have permissions to use KVM (/dev/kvm)."},
+		{log: "kvm_missing.log", want: []FailureKind{FailureKVMMissing}, wantLine: "CPU acceleration status: KVM requires a CPU that supports vmx or svm"},
+		{log: "unknown_avd.log", want: []FailureKind{FailureUnknownAVD}, wantLine: "PANIC: Unknown AVD name [test], use -list-avds to see valid list."},
+		{log: "qemu_system_not_found.log", want: []FailureKind{FailureEngineNotFound}, wantLine: "PANIC: Missing emulator engine program for 'x86' CPU."},
+		{log: "missing_system_image.log", want: []FailureKind{FailureMissingSystemImage}, wantLine: "PANIC: Cannot find AVD system path. Please define ANDROID_SDK_ROOT"},
+		{log: "corrupted_snapshot.log", want: []FailureKind{FailureCorruptedSnapshot}, wantLine: "qemu-system-x86_64: Failed to load snapshot 'default_boot': the snapshot is corrupted"},
+		{log: "out_of_memory.log", want: []FailureKind{FailureOutOfMemory}, wantLine: "qemu-system-x86_64: -m 4096: failed to allocate 4294967296 bytes of guest memory"},
+		{log: "gpu_init_failed.log", want: []FailureKind{FailureGPUInit}, wantLine: "libGL error: unable to load driver: swrast_dri.so"},
+		{log: "boot_completed.log"},
+	}
+
+	for _, tt := range tests {
+		failures, err := AnalyzeLogFile(filepath.Join("testdata", tt.log))
+		if err != nil {
+			t.Errorf("%s: AnalyzeLogFile() error: %s", tt.log, err)
+			continue
+		}
+
+		var kinds []FailureKind
+		for _, failure := range failures {
+			kinds = append(kinds, failure.Kind)
+		}
+		if !reflect.DeepEqual(kinds, tt.want) {
+			t.Errorf("%s: kinds = %v, want %v", tt.log, kinds, tt.want)
+			continue
+		}
+
+		if len(failures) > 0 {
+			if failures[0].Line != tt.wantLine {
+				t.Errorf("%s: line = %q, want %q", tt.log, failures[0].Line, tt.wantLine)
+			}
+			if failures[0].Explanation == "" || failures[0].Fix == "" {
+				t.Errorf("%s: missing explanation or fix: %+v", tt.log, failures[0])
+			}
+		}
+	}
+}
+
+func TestAnalyzeLogOrder(t *testing.T) {
+	output := "libGL error: failed to load driver: swrast\nPANIC: Unknown AVD name [test]\n"
+
+	var kinds []FailureKind
+	for _, failure := range AnalyzeLog(output) {
+		kinds = append(kinds, failure.Kind)
+	}
+	if want := []FailureKind{FailureUnknownAVD, FailureGPUInit}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("kinds = %v, want %v", kinds, want)
+	}
+}
+
+func TestAnalyzeLogFileMissing(t *testing.T) {
+	if _, err := AnalyzeLogFile(filepath.Join("testdata", "missing.log")); err == nil {
+		t.Errorf("AnalyzeLogFile() of a missing file, want error")
+	}
+}
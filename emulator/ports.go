@@ -0,0 +1,320 @@
+package emulator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// The emulator console ports, each emulator uses an even console port and the following odd adb port.
+const (
+	FirstConsolePort = 5554
+	LastConsolePort  = 5584
+)
+
+// PortAllocator hands out console/adb port pairs to AVDs.
+// A port pair is free if both ports can be bound, no running emulator claims it
+// and no other existing AVD has reserved it.
+type PortAllocator struct {
+	AVDHome        string
+	ReservationDir string
+}
+
+// NewPortAllocator creates an allocator keeping its reservations in the temp dir.
+func NewPortAllocator(avdHome string) PortAllocator {
+	return PortAllocator{
+		AVDHome:        avdHome,
+		ReservationDir: filepath.Join(os.TempDir(), "bitrise-emulator-ports"),
+	}
+}
+
+func (allocator PortAllocator) reservationPth(port int) string {
+	return filepath.Join(allocator.ReservationDir, strconv.Itoa(port)+".lock")
+}
+
+// Reserve returns the port reserved for the AVD, or reserves the first free port pair.
+func (allocator PortAllocator) Reserve(name string) (int, error) {
+	if err := os.MkdirAll(allocator.ReservationDir, 0755); err != nil {
+		return 0, err
+	}
+
+	reservations, err := allocator.reservations()
+	if err != nil {
+		return 0, err
+	}
+	for port, owner := range reservations {
+		if owner == name {
+			return port, nil
+		}
+	}
+
+	claimed := RunningEmulatorPorts(allocator.AVDHome)
+
+	for port := FirstConsolePort; port <= LastConsolePort; port += 2 {
+		if _, reserved := reservations[port]; reserved || claimed[port] || !isPortPairFree(port) {
+			continue
+		}
+
+		if ok, err := allocator.createReservation(name, port); err != nil {
+			return 0, err
+		} else if ok {
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free emulator port pair between %d and %d", FirstConsolePort, LastConsolePort+1)
+}
+
+// ReservePort reserves the given console port for the AVD,
+// it fails if the port pair is reserved for an other AVD, claimed by a running emulator or not free.
+func (allocator PortAllocator) ReservePort(name string, port int) error {
+	if port < FirstConsolePort || port > LastConsolePort || port%2 != 0 {
+		return fmt.Errorf("invalid console port (%d), should be an even number between %d and %d", port, FirstConsolePort, LastConsolePort)
+	}
+
+	if err := os.MkdirAll(allocator.ReservationDir, 0755); err != nil {
+		return err
+	}
+
+	reservations, err := allocator.reservations()
+	if err != nil {
+		return err
+	}
+
+	if owner, reserved := reservations[port]; reserved {
+		if owner == name {
+			return nil
+		}
+		return fmt.Errorf("port %d is reserved for AVD: %s", port, owner)
+	}
+	for reservedPort, owner := range reservations {
+		if owner == name {
+			return fmt.Errorf("AVD (%s) already has port %d reserved", name, reservedPort)
+		}
+	}
+
+	if RunningEmulatorPorts(allocator.AVDHome)[port] {
+		return fmt.Errorf("port %d is used by a running emulator", port)
+	}
+	if !isPortPairFree(port) {
+		return fmt.Errorf("port %d or %d is in use", port, port+1)
+	}
+
+	if ok, err := allocator.createReservation(name, port); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("port %d was reserved concurrently by an other process", port)
+	}
+	return nil
+}
+
+// createReservation creates the reservation file exclusively, returns false if it already exists.
+func (allocator PortAllocator) createReservation(name string, port int) (bool, error) {
+	f, err := os.OpenFile(allocator.reservationPth(port), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if _, err := f.WriteString(name); err != nil {
+		_ = f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
+
+// Release drops the port reservation of the AVD.
+func (allocator PortAllocator) Release(name string) error {
+	reservations, err := allocator.reservations()
+	if err != nil {
+		return err
+	}
+	for port, owner := range reservations {
+		if owner == name {
+			return os.Remove(allocator.reservationPth(port))
+		}
+	}
+	return nil
+}
+
+// reservations returns the AVD names by reserved port,
+// reservations of AVDs which no longer exist are removed.
+func (allocator PortAllocator) reservations() (map[int]string, error) {
+	pths, err := filepath.Glob(filepath.Join(allocator.ReservationDir, "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := map[int]string{}
+	for _, pth := range pths {
+		port, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(pth), ".lock"))
+		if err != nil {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSpace(string(content))
+
+		if exist, err := pathutil.IsDirExists(filepath.Join(allocator.AVDHome, name+".avd")); err != nil {
+			return nil, err
+		} else if !exist {
+			if err := os.Remove(pth); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+
+		reservations[port] = name
+	}
+	return reservations, nil
+}
+
+func isPortPairFree(port int) bool {
+	for _, p := range []int{port, port + 1} {
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(p)))
+		if err != nil {
+			return false
+		}
+		_ = l.Close()
+	}
+	return true
+}
+
+// RunningEmulatorPorts returns the console ports claimed by running emulators,
+// collected from the emulator discovery files (avd/running/pid_<pid>.ini)
+// and from the command line of the processes holding an AVD lock (<name>.avd/*.ini.lock).
+func RunningEmulatorPorts(avdHome string) map[int]bool {
+	ports := map[int]bool{}
+
+	for _, dir := range discoveryDirs() {
+		pths, _ := filepath.Glob(filepath.Join(dir, "pid_*.ini"))
+		for _, pth := range pths {
+			pid, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(pth), "pid_"), ".ini"))
+			if err != nil || !isProcessAlive(pid) {
+				continue
+			}
+			if port, ok := discoveryFilePort(pth); ok {
+				ports[port] = true
+			}
+		}
+	}
+
+	for _, port := range RunningAVDs(avdHome) {
+		ports[port] = true
+	}
+
+	return ports
+}
+
+// RunningAVDs returns the console ports by AVD name of the running emulators,
+// collected from the processes holding an AVD lock (<name>.avd/*.ini.lock).
+func RunningAVDs(avdHome string) map[string]int {
+	avds := map[string]int{}
+
+	locks, _ := filepath.Glob(filepath.Join(avdHome, "*.avd", "*.ini.lock"))
+	for _, lock := range locks {
+		pid, ok := lockOwner(lock)
+		if !ok || !isProcessAlive(pid) {
+			continue
+		}
+		if port, ok := processConsolePort(pid); ok {
+			avds[strings.TrimSuffix(filepath.Base(filepath.Dir(lock)), ".avd")] = port
+		}
+	}
+
+	return avds
+}
+
+func discoveryDirs() []string {
+	dirs := []string{filepath.Join(os.TempDir(), "avd", "running")}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dirs = append(dirs, filepath.Join(dir, "avd", "running"))
+	}
+	if user := os.Getenv("USER"); user != "" {
+		dirs = append(dirs, filepath.Join(os.TempDir(), "android-"+user, "avd", "running"))
+	}
+	return append(dirs, filepath.Join(pathutil.UserHomeDir(), ".android", "avd", "running"))
+}
+
+func discoveryFilePort(pth string) (int, bool) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "port.serial=") {
+			port, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "port.serial=")))
+			return port, err == nil
+		}
+	}
+	return 0, false
+}
+
+// lockOwner reads the pid from an emulator lock, which is either a file or a directory holding a pid file.
+func lockOwner(lock string) (int, bool) {
+	pth := lock
+	if isDir, err := pathutil.IsDirExists(lock); err == nil && isDir {
+		pth = filepath.Join(lock, "pid")
+	}
+
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid, err == nil
+}
+
+// processConsolePort reads the -port argument from the command line of the process.
+func processConsolePort(pid int) (int, bool) {
+	args, ok := processArgs(pid)
+	if !ok {
+		return 0, false
+	}
+	return consolePortArg(args)
+}
+
+// processArgs returns the command line of the process, from /proc on linux,
+// and from ps elsewhere (like on macOS), where the arguments are split on whitespace.
+func processArgs(pid int) ([]string, bool) {
+	if content, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline")); err == nil {
+		return strings.Split(string(content), "\x00"), true
+	}
+
+	return psArgs(pid)
+}
+
+func psArgs(pid int) ([]string, bool) {
+	out, err := exec.Command("ps", "-o", "args=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return nil, false
+	}
+	return strings.Fields(string(out)), true
+}
+
+func consolePortArg(args []string) (int, bool) {
+	for i, arg := range args {
+		if arg == "-port" && i+1 < len(args) {
+			port, err := strconv.Atoi(args[i+1])
+			return port, err == nil
+		}
+	}
+	return 0, false
+}
+
+func isProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,181 @@
+package emulator
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func newTestAllocator(t *testing.T, avds ...string) (PortAllocator, func()) {
+	dir, err := ioutil.TempDir("", "ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocator := PortAllocator{AVDHome: filepath.Join(dir, "avd"), ReservationDir: filepath.Join(dir, "reservations")}
+	for _, name := range avds {
+		if err := os.MkdirAll(filepath.Join(allocator.AVDHome, name+".avd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return allocator, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	allocator, cleanup := newTestAllocator(t, "first", "second")
+	defer cleanup()
+
+	first, err := allocator.Reserve("first")
+	if err != nil {
+		t.Fatalf("Reserve(first) error: %s", err)
+	}
+	if first < FirstConsolePort || first > LastConsolePort || first%2 != 0 {
+		t.Errorf("Reserve(first) = %d, want an even port between %d and %d", first, FirstConsolePort, LastConsolePort)
+	}
+
+	if again, err := allocator.Reserve("first"); err != nil || again != first {
+		t.Errorf("Reserve(first) again = %d, %v, want %d", again, err, first)
+	}
+
+	second, err := allocator.Reserve("second")
+	if err != nil {
+		t.Fatalf("Reserve(second) error: %s", err)
+	}
+	if second == first {
+		t.Errorf("Reserve(second) = %d, the port of first", second)
+	}
+
+	reservations, err := allocator.reservations()
+	if err != nil {
+		t.Fatalf("reservations() error: %s", err)
+	}
+	if want := map[int]string{first: "first", second: "second"}; !reflect.DeepEqual(reservations, want) {
+		t.Errorf("reservations() = %v, want %v", reservations, want)
+	}
+}
+
+func TestReservePort(t *testing.T) {
+	allocator, cleanup := newTestAllocator(t, "first", "second")
+	defer cleanup()
+
+	for _, port := range []int{5553, 5555, 5586, 80} {
+		if err := allocator.ReservePort("first", port); err == nil {
+			t.Errorf("ReservePort(%d), want invalid port error", port)
+		}
+	}
+
+	port, err := allocator.Reserve("first")
+	if err != nil {
+		t.Fatalf("Reserve(first) error: %s", err)
+	}
+
+	if err := allocator.ReservePort("first", port); err != nil {
+		t.Errorf("ReservePort() of the own reservation error: %s", err)
+	}
+	if err := allocator.ReservePort("second", port); err == nil {
+		t.Errorf("ReservePort() of the port of an other AVD, want error")
+	}
+
+	other := port + 2
+	if other > LastConsolePort {
+		other = FirstConsolePort
+	}
+	if err := allocator.ReservePort("first", other); err == nil {
+		t.Errorf("ReservePort() of a second port for the AVD, want error")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	allocator, cleanup := newTestAllocator(t, "first")
+	defer cleanup()
+
+	if err := allocator.Release("first"); err != nil {
+		t.Errorf("Release() without reservation error: %s", err)
+	}
+
+	port, err := allocator.Reserve("first")
+	if err != nil {
+		t.Fatalf("Reserve() error: %s", err)
+	}
+	if err := allocator.Release("first"); err != nil {
+		t.Fatalf("Release() error: %s", err)
+	}
+	if _, err := os.Stat(allocator.reservationPth(port)); !os.IsNotExist(err) {
+		t.Errorf("reservation file of port %d still exists", port)
+	}
+}
+
+func TestStaleReservationsRemoved(t *testing.T) {
+	allocator, cleanup := newTestAllocator(t, "first")
+	defer cleanup()
+
+	if err := os.MkdirAll(allocator.ReservationDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := allocator.createReservation("deleted", 5570); err != nil || !ok {
+		t.Fatalf("createReservation() = %v, %v", ok, err)
+	}
+	if ok, err := allocator.createReservation("first", 5570); err != nil || ok {
+		t.Errorf("createReservation() of a reserved port = %v, %v, want false", ok, err)
+	}
+
+	reservations, err := allocator.reservations()
+	if err != nil {
+		t.Fatalf("reservations() error: %s", err)
+	}
+	if len(reservations) != 0 {
+		t.Errorf("reservations() = %v, want none", reservations)
+	}
+	if _, err := os.Stat(allocator.reservationPth(5570)); !os.IsNotExist(err) {
+		t.Errorf("stale reservation file still exists")
+	}
+}
+
+func TestRunningAVDs(t *testing.T) {
+	allocator, cleanup := newTestAllocator(t, "running", "stopped")
+	defer cleanup()
+
+	cmd := exec.Command("sh", "-c", "sleep 30", "emulator", "-avd", "running", "-port", "5580")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+
+	for _, args := range []func(int) ([]string, bool){processArgs, psArgs} {
+		if got, ok := args(pid); !ok {
+			t.Errorf("failed to read the args of the process")
+		} else if port, ok := consolePortArg(got); !ok || port != 5580 {
+			t.Errorf("consolePortArg(%v) = %d, %v, want 5580", got, port, ok)
+		}
+	}
+
+	lock := filepath.Join(allocator.AVDHome, "running.avd", "hardware-qemu.ini.lock")
+	if err := ioutil.WriteFile(lock, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadLock := filepath.Join(allocator.AVDHome, "stopped.avd", "hardware-qemu.ini.lock")
+	if err := os.MkdirAll(deadLock, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(deadLock, "pid"), []byte("999999999"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := RunningAVDs(allocator.AVDHome), map[string]int{"running": 5580}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RunningAVDs() = %v, want %v", got, want)
+	}
+	if !RunningEmulatorPorts(allocator.AVDHome)[5580] {
+		t.Errorf("RunningEmulatorPorts() does not contain 5580")
+	}
+}
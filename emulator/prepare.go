@@ -0,0 +1,206 @@
+package emulator
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviceShell runs shell command lines on a device, implemented by both the adb command line wrapper and the native adb client.
+type DeviceShell interface {
+	Shell(serial, cmd string) (string, error)
+}
+
+// PrepareOptions are the optional settings of the device preparation.
+type PrepareOptions struct {
+	// Locale is a BCP 47 language tag, like en-US.
+	Locale string
+	// Timezone is an Olson timezone ID, like Europe/Budapest.
+	Timezone string
+	// RestartTimeout is the time to wait for the Android framework to come back after a locale change.
+	RestartTimeout time.Duration
+}
+
+// PrepareResult is the outcome of a single preparation step, verified by reading the setting back.
+type PrepareResult struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+type prepareStep struct {
+	name     string
+	apply    []string
+	read     string
+	expected string
+	verify   func(actual string) bool
+}
+
+var keyguardShowingPattern = regexp.MustCompile(`(?i)(showingLockscreen|dreamingLockscreen|isStatusBarKeyguard|\bshowing)=true`)
+
+// uiTestingSteps make the device predictable for UI tests.
+var uiTestingSteps = []prepareStep{
+	globalSettingStep("window_animation_scale", "0", isZero),
+	globalSettingStep("transition_animation_scale", "0", isZero),
+	globalSettingStep("animator_duration_scale", "0", isZero),
+	// stay awake while plugged in to AC, USB or wireless
+	globalSettingStep("stay_on_while_plugged_in", "7", nil),
+	{
+		name:     "show_ime_with_hard_keyboard",
+		apply:    []string{"settings put secure show_ime_with_hard_keyboard 0"},
+		read:     "settings get secure show_ime_with_hard_keyboard",
+		expected: "0",
+	},
+	{
+		name:     "unlock_screen",
+		apply:    []string{"input keyevent 82", "wm dismiss-keyguard"},
+		read:     "dumpsys window policy | grep -iE 'showingLockscreen|dreamingLockscreen|isStatusBarKeyguard|showing='; true",
+		expected: "keyguard not showing",
+		verify: func(actual string) bool {
+			return !keyguardShowingPattern.MatchString(actual)
+		},
+	},
+}
+
+func globalSettingStep(name, value string, verify func(string) bool) prepareStep {
+	return prepareStep{
+		name:     name,
+		apply:    []string{fmt.Sprintf("settings put global %s %s", name, value)},
+		read:     "settings get global " + name,
+		expected: value,
+		verify:   verify,
+	}
+}
+
+func isZero(actual string) bool {
+	value, err := strconv.ParseFloat(actual, 64)
+	return err == nil && value == 0
+}
+
+// PrepareDevice prepares the booted device for UI testing:
+// it sets the optional locale and timezone, disables the animations, keeps the screen on,
+// disables the soft keyboard for hardware keyboards and unlocks the screen.
+// Every setting is read back, the results are returned in the order of the steps.
+func PrepareDevice(shell DeviceShell, serial string, opts PrepareOptions) []PrepareResult {
+	var results []PrepareResult
+
+	var systemSteps []prepareStep
+	if opts.Locale != "" {
+		systemSteps = append(systemSteps, propertyStep("persist.sys.locale", opts.Locale))
+	}
+	if opts.Timezone != "" {
+		systemSteps = append(systemSteps, propertyStep("persist.sys.timezone", opts.Timezone))
+	}
+
+	// setprop of the persist.sys properties is silently ignored without root
+	var rootErr error
+	if len(systemSteps) > 0 {
+		rootErr = checkRootShell(shell, serial)
+	}
+
+	localeChanged := false
+	if opts.Locale != "" && rootErr == nil {
+		current, err := shell.Shell(serial, "getprop persist.sys.locale")
+		localeChanged = err != nil || current != opts.Locale
+	}
+
+	for _, step := range systemSteps {
+		if rootErr != nil {
+			results = append(results, PrepareResult{Name: step.name, Expected: step.expected, Error: rootErr.Error()})
+			continue
+		}
+		results = append(results, runPrepareStep(shell, serial, step))
+	}
+
+	// the locale is read by the Android framework on start
+	if localeChanged {
+		result := PrepareResult{Name: "restart_framework", Expected: "package manager running"}
+		if err := restartFramework(shell, serial, opts.RestartTimeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Actual = result.Expected
+			result.Verified = true
+		}
+		results = append(results, result)
+	}
+
+	for _, step := range uiTestingSteps {
+		results = append(results, runPrepareStep(shell, serial, step))
+	}
+
+	return results
+}
+
+func propertyStep(property, value string) prepareStep {
+	return prepareStep{
+		name:     property,
+		apply:    []string{fmt.Sprintf("setprop %s %s", property, value)},
+		read:     "getprop " + property,
+		expected: value,
+	}
+}
+
+// checkRootShell returns an error if the device shell does not run as root.
+func checkRootShell(shell DeviceShell, serial string) error {
+	uid, err := shell.Shell(serial, "id -u")
+	if err != nil {
+		return fmt.Errorf("failed to check the device shell user, error: %s", err)
+	}
+	if uid == "0" {
+		return nil
+	}
+
+	buildType, _ := shell.Shell(serial, "getprop ro.build.type")
+	if buildType == "user" {
+		return fmt.Errorf("setting the property requires root, which is not available on user builds (ro.build.type=%s): use an image without the Play Store, like google_apis or default", buildType)
+	}
+	return fmt.Errorf("setting the property requires root, but the device shell runs as uid %s (ro.build.type=%s): restart adbd as root with adb -s %s root", uid, buildType, serial)
+}
+
+func runPrepareStep(shell DeviceShell, serial string, step prepareStep) PrepareResult {
+	result := PrepareResult{Name: step.name, Expected: step.expected}
+
+	var applyErr error
+	for _, cmd := range step.apply {
+		if _, err := shell.Shell(serial, cmd); err != nil && applyErr == nil {
+			applyErr = err
+		}
+	}
+
+	actual, err := shell.Shell(serial, step.read)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	verify := step.verify
+	if verify == nil {
+		verify = func(actual string) bool { return actual == step.expected }
+	}
+
+	result.Actual = strings.Join(strings.Fields(actual), " ")
+	if result.Verified = verify(actual); !result.Verified && applyErr != nil {
+		result.Error = applyErr.Error()
+	}
+	return result
+}
+
+// restartFramework restarts the Android framework and waits until the package manager is back.
+func restartFramework(shell DeviceShell, serial string, timeout time.Duration) error {
+	if _, err := shell.Shell(serial, "stop && start"); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		if out, err := shell.Shell(serial, "pm path android"); err == nil && strings.HasPrefix(out, "package:") {
+			return nil
+		}
+	}
+	return fmt.Errorf("the Android framework did not restart in %s", timeout)
+}
@@ -0,0 +1,81 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeShell answers the commands from a map and records them, setprop updates the answer of getprop.
+type fakeShell struct {
+	responses map[string]string
+	commands  []string
+}
+
+func (shell *fakeShell) Shell(serial, cmd string) (string, error) {
+	shell.commands = append(shell.commands, cmd)
+	if strings.HasPrefix(cmd, "setprop ") {
+		fields := strings.Fields(cmd)
+		shell.responses["getprop "+fields[1]] = fields[2]
+	}
+	return shell.responses[cmd], nil
+}
+
+func (shell *fakeShell) ran(prefix string) bool {
+	for _, cmd := range shell.commands {
+		if strings.HasPrefix(cmd, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func findResult(results []PrepareResult, name string) (PrepareResult, bool) {
+	for _, result := range results {
+		if result.Name == name {
+			return result, true
+		}
+	}
+	return PrepareResult{}, false
+}
+
+func TestPrepareDeviceTimezone(t *testing.T) {
+	tests := []struct {
+		name         string
+		uid          string
+		buildType    string
+		wantVerified bool
+		wantError    string
+	}{
+		{name: "root", uid: "0", buildType: "userdebug", wantVerified: true},
+		{name: "userdebug without adb root", uid: "2000", buildType: "userdebug", wantError: "adb -s emulator-5554 root"},
+		{name: "user build", uid: "2000", buildType: "user", wantError: "not available on user builds"},
+	}
+
+	for _, tt := range tests {
+		shell := &fakeShell{responses: map[string]string{
+			"id -u":                 tt.uid,
+			"getprop ro.build.type": tt.buildType,
+			"settings get global x": "",
+		}}
+
+		results := PrepareDevice(shell, "emulator-5554", PrepareOptions{Timezone: "Europe/Budapest"})
+
+		result, ok := findResult(results, "persist.sys.timezone")
+		if !ok {
+			t.Errorf("%s: no persist.sys.timezone result", tt.name)
+			continue
+		}
+		if result.Verified != tt.wantVerified {
+			t.Errorf("%s: Verified = %v, want %v", tt.name, result.Verified, tt.wantVerified)
+		}
+		if !strings.Contains(result.Error, tt.wantError) {
+			t.Errorf("%s: Error = %q, want it to contain %q", tt.name, result.Error, tt.wantError)
+		}
+		if ran := shell.ran("setprop persist.sys.timezone"); ran != (tt.uid == "0") {
+			t.Errorf("%s: setprop ran = %v", tt.name, ran)
+		}
+		if _, ok := findResult(results, "window_animation_scale"); !ok {
+			t.Errorf("%s: the UI testing steps did not run", tt.name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// ErrorKind classifies the step failures, each kind exits with its own code.
+type ErrorKind string
+
+// Error kinds
+const (
+	errFailed             ErrorKind = "failed"
+	errInvalidInput       ErrorKind = "invalid_input"
+	errSDKToolMissing     ErrorKind = "sdk_tool_missing"
+	errInstallFailed      ErrorKind = "install_failed"
+	errLicenseMissing     ErrorKind = "license_missing"
+	errAVDCreateFailed    ErrorKind = "avd_create_failed"
+	errProfileWriteFailed ErrorKind = "profile_write_failed"
+	errExportFailed       ErrorKind = "export_failed"
+	errCanceled           ErrorKind = "canceled"
+)
+
+// exitCodes are documented in the step.yml description, failures of other kinds exit with 1.
+var exitCodes = map[ErrorKind]int{
+	errFailed:             1,
+	errInvalidInput:       2,
+	errSDKToolMissing:     3,
+	errInstallFailed:      4,
+	errLicenseMissing:     5,
+	errAVDCreateFailed:    6,
+	errProfileWriteFailed: 7,
+	errExportFailed:       8,
+	errCanceled:           9,
+}
+
+const errorFileName = "create-android-emulator-error.json"
+
+// StepError is a classified step failure.
+type StepError struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func newStepError(kind ErrorKind, err error, format string, v ...interface{}) *StepError {
+	return &StepError{Kind: kind, Message: fmt.Sprintf(format, v...), Err: err}
+}
+
+// Error ...
+func (e *StepError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s, error: %s", e.Message, e.Err)
+}
+
+// ExitCode returns the documented exit code of the error kind.
+func (e *StepError) ExitCode() int {
+	if code, ok := exitCodes[e.Kind]; ok {
+		return code
+	}
+	return 1
+}
+
+// errorKind returns the kind of the error, or kind if it is not classified yet.
+func errorKind(err error, kind ErrorKind) ErrorKind {
+	if stepErr, ok := err.(*StepError); ok {
+		return stepErr.Kind
+	}
+	return kind
+}
+
+// asStepError classifies unclassified errors as generic failures.
+func asStepError(err error) *StepError {
+	if stepErr, ok := err.(*StepError); ok {
+		return stepErr
+	}
+	return &StepError{Kind: errFailed, Message: err.Error()}
+}
+
+// ErrorFileModel is the machine-readable form of the failure written into the deploy dir.
+type ErrorFileModel struct {
+	Kind     ErrorKind `json:"kind"`
+	ExitCode int       `json:"exit_code"`
+	Phase    string    `json:"phase,omitempty"`
+	Message  string    `json:"message"`
+}
+
+func writeErrorFile(pth string, stepErr *StepError, phase string) error {
+	b, err := json.MarshalIndent(ErrorFileModel{
+		Kind:     stepErr.Kind,
+		ExitCode: stepErr.ExitCode(),
+		Phase:    phase,
+		Message:  stepErr.Error(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, b)
+}
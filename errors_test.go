@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// stepExitCodePattern matches the exit codes documented in the step.yml description, like: - `2` (`invalid_input`): ...
+var stepExitCodePattern = regexp.MustCompile("(?m)^  - `([0-9]+)` \\(`([a-z_]+)`\\): ")
+
+func TestExitCodes(t *testing.T) {
+	b, err := ioutil.ReadFile("step.yml")
+	if err != nil {
+		t.Fatalf("failed to read step.yml: %s", err)
+	}
+
+	documented := map[ErrorKind]int{}
+	for _, match := range stepExitCodePattern.FindAllStringSubmatch(string(b), -1) {
+		code, err := strconv.Atoi(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		documented[ErrorKind(match[2])] = code
+	}
+	if !reflect.DeepEqual(exitCodes, documented) {
+		t.Errorf("exitCodes = %v, want the step.yml exit codes %v", exitCodes, documented)
+	}
+
+	for kind, code := range documented {
+		if got := newStepError(kind, nil, "test").ExitCode(); got != code {
+			t.Errorf("ExitCode() of %s = %d, want %d", kind, got, code)
+		}
+	}
+	if got := newStepError("unknown", nil, "test").ExitCode(); got != 1 {
+		t.Errorf("ExitCode() of an unknown kind = %d, want 1", got)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	stepErr := newStepError(errInstallFailed, errors.New("exit status 1"), "failed to install platform")
+	plainErr := errors.New("exit status 1")
+
+	if kind := errorKind(stepErr, errFailed); kind != errInstallFailed {
+		t.Errorf("errorKind() of a step error = %s, want %s", kind, errInstallFailed)
+	}
+	if kind := errorKind(plainErr, errAVDCreateFailed); kind != errAVDCreateFailed {
+		t.Errorf("errorKind() of an unclassified error = %s, want the given %s", kind, errAVDCreateFailed)
+	}
+
+	if got := asStepError(stepErr); got != stepErr {
+		t.Errorf("asStepError() = %v, want the step error itself", got)
+	}
+	if got := asStepError(plainErr); got.Kind != errFailed || got.ExitCode() != 1 || got.Error() != plainErr.Error() {
+		t.Errorf("asStepError() = %+v, want a failed error with the message", got)
+	}
+
+	if want := "failed to install platform, error: exit status 1"; stepErr.Error() != want {
+		t.Errorf("Error() = %s, want %s", stepErr.Error(), want)
+	}
+}
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-tools/go-steputils/tools"
+)
+
+// Exporter types
+const (
+	TypeAuto   = "auto"
+	TypeEnvman = "envman"
+	TypeGitHub = "github"
+	TypeDotenv = "dotenv"
+	TypeStdout = "stdout"
+)
+
+// Types lists the selectable exporter types.
+var Types = []string{TypeAuto, TypeEnvman, TypeGitHub, TypeDotenv, TypeStdout}
+
+// Exporter makes a key-value pair available for the subsequent steps of the pipeline.
+type Exporter interface {
+	Export(key, value string) error
+}
+
+// New creates the exporter of the given type.
+// The auto type selects envman if it is on the PATH, the GitHub Actions exporter if $GITHUB_ENV is set,
+// and falls back to the stdout exporter.
+func New(exporterType, dotenvPth string) (Exporter, error) {
+	switch exporterType {
+	case TypeAuto:
+		if _, err := exec.LookPath("envman"); err == nil {
+			return EnvmanExporter{}, nil
+		}
+		if os.Getenv("GITHUB_ENV") != "" {
+			return NewGitHubExporter()
+		}
+		return StdoutExporter{Writer: os.Stdout}, nil
+	case TypeEnvman:
+		return EnvmanExporter{}, nil
+	case TypeGitHub:
+		return NewGitHubExporter()
+	case TypeDotenv:
+		if dotenvPth == "" {
+			return nil, errors.New("no dotenv file path specified")
+		}
+		return DotenvExporter{Path: dotenvPth}, nil
+	case TypeStdout:
+		return StdoutExporter{Writer: os.Stdout}, nil
+	}
+	return nil, fmt.Errorf("unknown exporter type (%s), valid options: %s", exporterType, Types)
+}
+
+// EnvmanExporter exports with envman, for Bitrise builds.
+type EnvmanExporter struct{}
+
+// Export ...
+func (EnvmanExporter) Export(key, value string) error {
+	return tools.ExportEnvironmentWithEnvman(key, value)
+}
+
+// String ...
+func (EnvmanExporter) String() string {
+	return TypeEnvman
+}
+
+// GitHubExporter appends the values to the $GITHUB_ENV and, if set, the $GITHUB_OUTPUT file of GitHub Actions.
+type GitHubExporter struct {
+	EnvPath    string
+	OutputPath string
+}
+
+// NewGitHubExporter ...
+func NewGitHubExporter() (GitHubExporter, error) {
+	envPth := os.Getenv("GITHUB_ENV")
+	if envPth == "" {
+		return GitHubExporter{}, errors.New("GITHUB_ENV is not set")
+	}
+	return GitHubExporter{EnvPath: envPth, OutputPath: os.Getenv("GITHUB_OUTPUT")}, nil
+}
+
+// Export writes the value in the multiline-safe heredoc format: key<<delimiter
+// The delimiter is regenerated until the value does not contain it.
+func (exporter GitHubExporter) Export(key, value string) error {
+	var delimiter string
+	for delimiter == "" || strings.Contains(value, delimiter) {
+		var err error
+		if delimiter, err = newDelimiter(); err != nil {
+			return err
+		}
+	}
+	entry := fmt.Sprintf("%s<<%s\n%s\n%s\n", key, delimiter, value, delimiter)
+
+	for _, pth := range []string{exporter.EnvPath, exporter.OutputPath} {
+		if pth == "" {
+			continue
+		}
+		if err := appendToFile(pth, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String ...
+func (GitHubExporter) String() string {
+	return TypeGitHub
+}
+
+// newDelimiter returns the heredoc delimiters, it is replaced in the tests.
+var newDelimiter = randomDelimiter
+
+func randomDelimiter() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "EOF_" + hex.EncodeToString(b), nil
+}
+
+// DotenvExporter appends KEY="value" lines to a dotenv file.
+type DotenvExporter struct {
+	Path string
+}
+
+// Export ...
+func (exporter DotenvExporter) Export(key, value string) error {
+	return appendToFile(exporter.Path, dotenvLine(key, value))
+}
+
+// String ...
+func (DotenvExporter) String() string {
+	return TypeDotenv
+}
+
+// StdoutExporter prints KEY="value" lines, for local runs and pipelines without any env store.
+type StdoutExporter struct {
+	Writer io.Writer
+}
+
+// Export ...
+func (exporter StdoutExporter) Export(key, value string) error {
+	_, err := io.WriteString(exporter.Writer, dotenvLine(key, value))
+	return err
+}
+
+// String ...
+func (StdoutExporter) String() string {
+	return TypeStdout
+}
+
+func dotenvLine(key, value string) string {
+	return key + "=" + strconv.Quote(value) + "\n"
+}
+
+func appendToFile(pth, content string) error {
+	f, err := os.OpenFile(pth, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,198 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseGitHubEnv reads the key<<delimiter heredoc entries of a $GITHUB_ENV file.
+func parseGitHubEnv(t *testing.T, content string) map[string]string {
+	values := map[string]string{}
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		split := strings.SplitN(lines[i], "<<", 2)
+		if len(split) != 2 {
+			t.Fatalf("invalid heredoc header: %s", lines[i])
+		}
+
+		var value []string
+		for i++; i < len(lines) && lines[i] != split[1]; i++ {
+			value = append(value, lines[i])
+		}
+		if i == len(lines) {
+			t.Fatalf("unterminated heredoc of %s", split[0])
+		}
+		values[split[0]] = strings.Join(value, "\n")
+	}
+	return values
+}
+
+func TestGitHubExporter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	// the first delimiter is part of the value, so it has to be regenerated
+	delimiters := []string{"EOF_1", "EOF_2", "EOF_3", "EOF_4"}
+	defer func(original func() (string, error)) {
+		newDelimiter = original
+	}(newDelimiter)
+	newDelimiter = func() (string, error) {
+		if len(delimiters) == 0 {
+			return "", fmt.Errorf("no more delimiters")
+		}
+		delimiter := delimiters[0]
+		delimiters = delimiters[1:]
+		return delimiter, nil
+	}
+
+	exporter := GitHubExporter{EnvPath: filepath.Join(tmpDir, "env"), OutputPath: filepath.Join(tmpDir, "output")}
+	want := map[string]string{
+		"BITRISE_EMULATOR_CACHE_PATHS": "/sdk/platforms/android-28\nEOF_1\n/sdk/system-images/android-28",
+		"BITRISE_EMULATOR_NAME":        "test",
+	}
+	for _, key := range []string{"BITRISE_EMULATOR_CACHE_PATHS", "BITRISE_EMULATOR_NAME"} {
+		if err := exporter.Export(key, want[key]); err != nil {
+			t.Fatalf("Export() error: %s", err)
+		}
+	}
+
+	for _, pth := range []string{exporter.EnvPath, exporter.OutputPath} {
+		content, err := ioutil.ReadFile(pth)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", pth, err)
+		}
+		if strings.Contains(string(content), "<<EOF_1\n") {
+			t.Errorf("%s uses the delimiter contained in the value:\n%s", pth, content)
+		}
+		if got := parseGitHubEnv(t, string(content)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", pth, got, want)
+		}
+	}
+}
+
+func TestDotenvExporter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	exporter := DotenvExporter{Path: filepath.Join(tmpDir, ".env")}
+	values := [][2]string{
+		{"NAME", "test"},
+		{"PATHS", "/a b\n/c"},
+		{"QUOTED", `say "hi" \ $HOME`},
+	}
+	for _, value := range values {
+		if err := exporter.Export(value[0], value[1]); err != nil {
+			t.Fatalf("Export() error: %s", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(exporter.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "NAME=\"test\"\nPATHS=\"/a b\\n/c\"\nQUOTED=\"say \\\"hi\\\" \\\\ $HOME\"\n"
+	if string(content) != want {
+		t.Errorf("dotenv file = %q, want %q", content, want)
+	}
+
+	for i, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		split := strings.SplitN(line, "=", 2)
+		value, err := strconv.Unquote(split[1])
+		if err != nil || split[0] != values[i][0] || value != values[i][1] {
+			t.Errorf("line %q = %s=%q (%v), want %s=%q", line, split[0], value, err, values[i][0], values[i][1])
+		}
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var b bytes.Buffer
+	if err := (StdoutExporter{Writer: &b}).Export("NAME", "te\"st"); err != nil {
+		t.Fatalf("Export() error: %s", err)
+	}
+	if want := "NAME=\"te\\\"st\"\n"; b.String() != want {
+		t.Errorf("Export() wrote %q, want %q", b.String(), want)
+	}
+}
+
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	_ = os.Setenv(key, value)
+	return func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
+
+func TestNewAuto(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	envmanDir := filepath.Join(tmpDir, "bin")
+	if err := os.MkdirAll(envmanDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(envmanDir, "envman"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		githubEnv string
+		want      string
+	}{
+		{name: "envman on the PATH", path: envmanDir, githubEnv: filepath.Join(tmpDir, "env"), want: TypeEnvman},
+		{name: "GitHub Actions", path: tmpDir, githubEnv: filepath.Join(tmpDir, "env"), want: TypeGitHub},
+		{name: "fallback", path: tmpDir, want: TypeStdout},
+	}
+
+	for _, tt := range tests {
+		restorePath := setenv("PATH", tt.path)
+		restoreGitHubEnv := setenv("GITHUB_ENV", tt.githubEnv)
+
+		exporter, err := New(TypeAuto, "")
+		if err != nil {
+			t.Errorf("%s: New() error: %s", tt.name, err)
+		} else if got := fmt.Sprint(exporter); got != tt.want {
+			t.Errorf("%s: New() = %s, want %s", tt.name, got, tt.want)
+		}
+
+		restoreGitHubEnv()
+		restorePath()
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	defer setenv("GITHUB_ENV", "")()
+
+	for _, args := range [][2]string{{TypeDotenv, ""}, {TypeGitHub, ""}, {"file", ""}} {
+		if _, err := New(args[0], args[1]); err == nil {
+			t.Errorf("New(%s, %q) error = nil, want error", args[0], args[1])
+		}
+	}
+}
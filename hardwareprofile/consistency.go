@@ -0,0 +1,78 @@
+package hardwareprofile
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+// Conflict is a profile key contradicting the system image the AVD is created with.
+// An empty Expected value means the key should be removed.
+type Conflict struct {
+	Key      string
+	Actual   string
+	Expected string
+}
+
+// String ...
+func (conflict Conflict) String() string {
+	if conflict.Expected == "" {
+		return fmt.Sprintf("%s=%s does not belong to the selected system image", conflict.Key, conflict.Actual)
+	}
+	return fmt.Sprintf("%s=%s, expected: %s", conflict.Key, conflict.Actual, conflict.Expected)
+}
+
+// CheckConsistency compares the system image related keys of the profile
+// (abi.type, hw.cpu.arch, image.sysdir.*, tag.id, PlayStore.enabled) with the given system image.
+// Keys missing from the profile are not reported.
+func CheckConsistency(profile Profile, systemImage sdkcomponent.SystemImage) []Conflict {
+	expected := map[string]string{}
+	for _, property := range SystemImageProperties(systemImage) {
+		expected[property.Key] = property.Value
+	}
+	sysDir := expected["image.sysdir.1"]
+
+	var conflicts []Conflict
+	for _, property := range profile.properties {
+		switch key := property.Key; {
+		case key == "image.sysdir.1":
+			if normalizeSysDir(property.Value) != sysDir {
+				conflicts = append(conflicts, Conflict{Key: key, Actual: property.Value, Expected: sysDir})
+			}
+		case strings.HasPrefix(key, "image.sysdir."):
+			if normalizeSysDir(property.Value) != sysDir {
+				conflicts = append(conflicts, Conflict{Key: key, Actual: property.Value})
+			}
+		case key == "PlayStore.enabled":
+			enabled, err := parseBool(property.Value)
+			if err != nil || formatBool(enabled) != formatBool(expected[key] == "true") {
+				conflicts = append(conflicts, Conflict{Key: key, Actual: property.Value, Expected: expected[key]})
+			}
+		case key == "abi.type", key == "hw.cpu.arch", key == "tag.id":
+			if property.Value != expected[key] {
+				conflicts = append(conflicts, Conflict{Key: key, Actual: property.Value, Expected: expected[key]})
+			}
+		}
+	}
+	return conflicts
+}
+
+func normalizeSysDir(dir string) string {
+	return path.Clean(strings.Replace(dir, "\\", "/", -1)) + "/"
+}
+
+// Fix sets the expected value of every conflicting key, or removes the key if no value is expected.
+func (profile *Profile) Fix(conflicts []Conflict) error {
+	for _, conflict := range conflicts {
+		if conflict.Expected == "" {
+			profile.Delete(conflict.Key)
+			continue
+		}
+		if err := profile.Set(conflict.Key, conflict.Expected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
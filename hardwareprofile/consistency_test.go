@@ -0,0 +1,58 @@
+package hardwareprofile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	systemImage := sdkcomponent.SystemImage{Platform: "android-28", Tag: "google_apis", ABI: "x86_64"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Conflict
+	}{
+		{name: "no system image keys", content: "hw.ramSize=2048\n"},
+		{
+			name:    "matching",
+			content: "abi.type=x86_64\nhw.cpu.arch=x86_64\nimage.sysdir.1=system-images\\android-28\\google_apis\\x86_64\ntag.id=google_apis\nPlayStore.enabled=no\n",
+		},
+		{
+			name:    "conflicting",
+			content: "abi.type=x86\nhw.cpu.arch=x86\nimage.sysdir.1=system-images/android-21/default/x86/\nimage.sysdir.2=system-images/android-21/default/x86/\ntag.id=default\nPlayStore.enabled=true\n",
+			want: []Conflict{
+				{Key: "abi.type", Actual: "x86", Expected: "x86_64"},
+				{Key: "hw.cpu.arch", Actual: "x86", Expected: "x86_64"},
+				{Key: "image.sysdir.1", Actual: "system-images/android-21/default/x86/", Expected: "system-images/android-28/google_apis/x86_64/"},
+				{Key: "image.sysdir.2", Actual: "system-images/android-21/default/x86/"},
+				{Key: "tag.id", Actual: "default", Expected: "google_apis"},
+				{Key: "PlayStore.enabled", Actual: "true", Expected: "false"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		profile, err := Parse(tt.content)
+		if err != nil {
+			t.Errorf("%s: Parse() error: %s", tt.name, err)
+			continue
+		}
+
+		conflicts := CheckConsistency(profile, systemImage)
+		if !reflect.DeepEqual(conflicts, tt.want) {
+			t.Errorf("%s: CheckConsistency() = %+v, want %+v", tt.name, conflicts, tt.want)
+			continue
+		}
+
+		if err := profile.Fix(conflicts); err != nil {
+			t.Errorf("%s: Fix() error: %s", tt.name, err)
+			continue
+		}
+		if conflicts := CheckConsistency(profile, systemImage); len(conflicts) != 0 {
+			t.Errorf("%s: conflicts after Fix() = %+v", tt.name, conflicts)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package hardwareprofile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Definition describes a config.ini key, as listed in the emulator's hardware-properties.ini.
+type Definition struct {
+	Name         string
+	Type         string
+	DefaultValue string
+	Enum         []string
+	Abstract     string
+}
+
+// Definitions maps the config.ini keys to their definitions.
+type Definitions map[string]Definition
+
+// avdKeys are AVD level config.ini keys, written by avdmanager or Android Studio,
+// which are not part of the emulator's hardware-properties.ini.
+var avdKeys = []string{
+	"AvdId",
+	"PlayStore.enabled",
+	"abi.type",
+	"sdcard.path",
+	"sdcard.size",
+	"showDeviceFrame",
+	"target",
+}
+
+var avdKeyPrefixes = []string{
+	"avd.",
+	"fastboot.",
+	"firstboot.",
+	"hw.device.",
+	"image.sysdir.",
+	"runtime.",
+	"skin.",
+	"snapshot.",
+	"tag.",
+}
+
+// DefinitionsPath returns the path of the hardware-properties.ini shipped with the emulator,
+// the legacy SDK Tools location is used as a fallback.
+func DefinitionsPath(androidHome string) (string, error) {
+	for _, pth := range []string{
+		filepath.Join(androidHome, "emulator", "lib", "hardware-properties.ini"),
+		filepath.Join(androidHome, "tools", "lib", "hardware-properties.ini"),
+	} {
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return "", err
+		} else if exist {
+			return pth, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// LoadDefinitions reads the hardware-properties.ini from the given Android SDK.
+func LoadDefinitions(androidHome string) (Definitions, error) {
+	pth, err := DefinitionsPath(androidHome)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDefinitions(content)
+}
+
+// ParseDefinitions parses the content of a hardware-properties.ini.
+// Each definition starts with a name line, followed by its type, default, enum and description lines.
+func ParseDefinitions(content string) (Definitions, error) {
+	definitions := Definitions{}
+	var current *Definition
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 {
+			// continuation of a multi-line description
+			continue
+		}
+
+		key := strings.TrimSpace(split[0])
+		value := strings.TrimSpace(split[1])
+
+		if key == "name" {
+			current = &Definition{Name: value}
+			definitions[value] = *current
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("line %d: %s defined before any property name", lineNum, key)
+		}
+
+		switch key {
+		case "type":
+			current.Type = value
+		case "default":
+			current.DefaultValue = value
+		case "abstract":
+			current.Abstract = value
+		case "enum":
+			for _, v := range strings.Split(value, ",") {
+				current.Enum = append(current.Enum, strings.TrimSpace(v))
+			}
+		}
+		definitions[current.Name] = *current
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(definitions) == 0 {
+		return nil, errors.New("no property definitions found")
+	}
+	return definitions, nil
+}
+
+// Severity ...
+type Severity int
+
+// Severities
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+// Issue is a problem found by checking a profile against the property definitions.
+type Issue struct {
+	Severity Severity
+	Line     int
+	Key      string
+	Message  string
+}
+
+// String ...
+func (issue Issue) String() string {
+	if issue.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", issue.Line, issue.Key, issue.Message)
+	}
+	return fmt.Sprintf("%s: %s", issue.Key, issue.Message)
+}
+
+// Check checks every key of the profile against the definitions.
+// Unknown keys are reported as warnings, unless they differ from a known key only in letter case,
+// wrong types and out-of-enum values are reported as errors.
+func (definitions Definitions) Check(profile Profile) []Issue {
+	var issues []Issue
+	for _, property := range profile.Properties() {
+		issue := Issue{Severity: SeverityError, Line: property.Line, Key: property.Key}
+
+		definition, ok := definitions[property.Key]
+		if !ok {
+			if isAVDKey(property.Key) {
+				continue
+			}
+
+			if known := definitions.lookupIgnoreCase(property.Key); known != "" {
+				issue.Message = fmt.Sprintf("unknown key, did you mean %s?", known)
+			} else {
+				issue.Severity = SeverityWarning
+				issue.Message = "unknown key, it is not described in hardware-properties.ini and will be ignored by the emulator"
+			}
+			issues = append(issues, issue)
+			continue
+		}
+
+		if err := definition.validate(property.Value); err != nil {
+			issue.Message = err.Error()
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+func (definitions Definitions) lookupIgnoreCase(key string) string {
+	for name := range definitions {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	for _, name := range avdKeys {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return ""
+}
+
+func isAVDKey(key string) bool {
+	for _, k := range avdKeys {
+		if k == key {
+			return true
+		}
+	}
+	for _, prefix := range avdKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (definition Definition) validate(value string) error {
+	switch definition.Type {
+	case "integer":
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid integer (%s)", value)
+		}
+	case "double":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("invalid number (%s)", value)
+		}
+	case "boolean":
+		if _, err := parseBool(value); err != nil {
+			return err
+		}
+	case "diskSize":
+		if _, err := ParseSize(value, Byte); err != nil {
+			return err
+		}
+	}
+
+	if len(definition.Enum) > 0 && !definition.allowsOpenValue(value) {
+		if _, err := parseEnum(value, definition.Enum...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowsOpenValue reports whether the enum only lists examples for the value:
+// enums ending with ... and the webcam<N> cameras.
+func (definition Definition) allowsOpenValue(value string) bool {
+	for _, v := range definition.Enum {
+		if strings.HasSuffix(v, "...") {
+			return true
+		}
+		if strings.HasPrefix(v, "webcam") && strings.HasPrefix(value, "webcam") {
+			return true
+		}
+	}
+	return false
+}
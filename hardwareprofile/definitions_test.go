@@ -0,0 +1,128 @@
+package hardwareprofile
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testDefinitions = `# hardware-properties.ini
+name        = hw.ramSize
+type        = integer
+default     = 0
+abstract    = Device ram size
+description = The amount of physical RAM on the device, in megabytes.
+  continued description
+
+name        = hw.initialOrientation
+type        = string
+enum        = portrait, landscape
+default     = portrait
+
+name        = hw.dPad
+type        = boolean
+default     = no
+
+name        = hw.sensors.temperature
+type        = double
+default     = 0.0
+
+name        = disk.cachePartition.size
+type        = diskSize
+default     = 66MB
+
+name        = hw.camera.back
+type        = string
+enum        = emulated, none, webcam0
+default     = emulated
+
+name        = hw.gpu.mode
+type        = string
+enum        = auto, host, ...
+default     = auto
+`
+
+func TestParseDefinitions(t *testing.T) {
+	definitions, err := ParseDefinitions(testDefinitions)
+	if err != nil {
+		t.Fatalf("ParseDefinitions() error: %s", err)
+	}
+
+	if len(definitions) != 7 {
+		t.Errorf("got %d definitions, want 7", len(definitions))
+	}
+
+	want := Definition{Name: "hw.ramSize", Type: "integer", DefaultValue: "0", Abstract: "Device ram size"}
+	if got := definitions["hw.ramSize"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("hw.ramSize = %+v, want %+v", got, want)
+	}
+
+	wantEnum := []string{"portrait", "landscape"}
+	if got := definitions["hw.initialOrientation"].Enum; !reflect.DeepEqual(got, wantEnum) {
+		t.Errorf("hw.initialOrientation enum = %v, want %v", got, wantEnum)
+	}
+}
+
+func TestParseDefinitionsErrors(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"# only comments\n",
+		"type = integer\nname = hw.ramSize\n",
+	} {
+		if _, err := ParseDefinitions(content); err == nil {
+			t.Errorf("ParseDefinitions(%q), want error", content)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	definitions, err := ParseDefinitions(testDefinitions)
+	if err != nil {
+		t.Fatalf("ParseDefinitions() error: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Issue
+	}{
+		{name: "valid", content: "hw.ramSize=2048\nhw.dPad=yes\nhw.sensors.temperature=21.5\ndisk.cachePartition.size=66MB\n"},
+		{name: "avd keys", content: "avd.ini.encoding=UTF-8\nimage.sysdir.1=system-images/android-28/default/x86/\nAvdId=test\n"},
+		{name: "enum ignores case", content: "hw.initialOrientation=Portrait\n"},
+		{name: "open enum", content: "hw.gpu.mode=swiftshader_indirect\nhw.camera.back=webcam1\n"},
+		{
+			name:    "unknown key",
+			content: "hw.unknown=1\n",
+			want:    []Issue{{Severity: SeverityWarning, Line: 1, Key: "hw.unknown", Message: "unknown key, it is not described in hardware-properties.ini and will be ignored by the emulator"}},
+		},
+		{
+			name:    "key typo",
+			content: "hw.ramsize=2048\n",
+			want:    []Issue{{Severity: SeverityError, Line: 1, Key: "hw.ramsize", Message: "unknown key, did you mean hw.ramSize?"}},
+		},
+		{
+			name:    "wrong types",
+			content: "hw.dPad=maybe\nhw.sensors.temperature=warm\ndisk.cachePartition.size=big\n",
+			want: []Issue{
+				{Severity: SeverityError, Line: 1, Key: "hw.dPad", Message: "invalid boolean (maybe), valid options: yes, no"},
+				{Severity: SeverityError, Line: 2, Key: "hw.sensors.temperature", Message: "invalid number (warm)"},
+				{Severity: SeverityError, Line: 3, Key: "disk.cachePartition.size", Message: "invalid size (big), expected a number optionally followed by K, M or G"},
+			},
+		},
+		{
+			name:    "out of enum",
+			content: "hw.initialOrientation=upside-down\n",
+			want:    []Issue{{Severity: SeverityError, Line: 1, Key: "hw.initialOrientation", Message: "invalid value (upside-down), valid options: [portrait landscape]"}},
+		},
+	}
+
+	for _, tt := range tests {
+		profile, err := Parse(tt.content)
+		if err != nil {
+			t.Errorf("%s: Parse() error: %s", tt.name, err)
+			continue
+		}
+		if got := definitions.Check(profile); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Check() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package hardwareprofile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hardware is the typed view of the commonly used hardware profile keys.
+// Keys missing from the profile keep their zero value.
+type Hardware struct {
+	RAMSize           Size   // hw.ramSize
+	VMHeapSize        Size   // vm.heapSize
+	LCDWidth          int    // hw.lcd.width
+	LCDHeight         int    // hw.lcd.height
+	LCDDensity        int    // hw.lcd.density
+	CPUCores          int    // hw.cpu.ncore
+	DataPartitionSize Size   // disk.dataPartition.size
+	SDCardSize        Size   // sdcard.size
+	GPUEnabled        bool   // hw.gpu.enabled
+	GPUMode           string // hw.gpu.mode
+	Keyboard          bool   // hw.keyboard
+	CameraFront       string // hw.camera.front
+	CameraBack        string // hw.camera.back
+}
+
+// decoder validates a raw value, stores it in the typed Hardware model
+// and returns the normalized value to write into config.ini.
+type decoder func(hw *Hardware, value string) (string, error)
+
+var decoders = map[string]decoder{
+	"hw.ramSize": func(hw *Hardware, value string) (string, error) {
+		size, err := parseMegabytes(value, 128, 64*1024)
+		hw.RAMSize = size
+		return strconv.FormatInt(size.Megabytes(), 10), err
+	},
+	"vm.heapSize": func(hw *Hardware, value string) (string, error) {
+		size, err := parseMegabytes(value, 16, 2048)
+		hw.VMHeapSize = size
+		return strconv.FormatInt(size.Megabytes(), 10), err
+	},
+	"hw.lcd.width": func(hw *Hardware, value string) (string, error) {
+		n, err := parseInt(value, 128, 8192)
+		hw.LCDWidth = n
+		return strconv.Itoa(n), err
+	},
+	"hw.lcd.height": func(hw *Hardware, value string) (string, error) {
+		n, err := parseInt(value, 128, 8192)
+		hw.LCDHeight = n
+		return strconv.Itoa(n), err
+	},
+	"hw.lcd.density": func(hw *Hardware, value string) (string, error) {
+		n, err := parseInt(value, 120, 640)
+		hw.LCDDensity = n
+		return strconv.Itoa(n), err
+	},
+	"hw.cpu.ncore": func(hw *Hardware, value string) (string, error) {
+		n, err := parseInt(value, 1, 16)
+		hw.CPUCores = n
+		return strconv.Itoa(n), err
+	},
+	"disk.dataPartition.size": func(hw *Hardware, value string) (string, error) {
+		size, err := ParseSize(value, Byte)
+		if err == nil && size != 0 && (size < 256*Megabyte || size > 1024*Gigabyte) {
+			err = fmt.Errorf("invalid size (%s), must be 0 (system default) or between 256M and 1024G", value)
+		}
+		hw.DataPartitionSize = size
+		return size.String(), err
+	},
+	"sdcard.size": func(hw *Hardware, value string) (string, error) {
+		size, err := ParseSize(value, Byte)
+		if err == nil && (size < 9*Megabyte || size > 1024*Gigabyte) {
+			err = fmt.Errorf("invalid size (%s), must be between 9M and 1024G", value)
+		}
+		hw.SDCardSize = size
+		return size.String(), err
+	},
+	"hw.gpu.enabled": func(hw *Hardware, value string) (string, error) {
+		b, err := parseBool(value)
+		hw.GPUEnabled = b
+		return formatBool(b), err
+	},
+	"hw.gpu.mode": func(hw *Hardware, value string) (string, error) {
+		mode, err := parseEnum(value, "auto", "host", "swiftshader_indirect", "angle_indirect", "guest", "mesa", "swiftshader", "angle", "off")
+		hw.GPUMode = mode
+		return mode, err
+	},
+	"hw.keyboard": func(hw *Hardware, value string) (string, error) {
+		b, err := parseBool(value)
+		hw.Keyboard = b
+		return formatBool(b), err
+	},
+	"hw.camera.front": func(hw *Hardware, value string) (string, error) {
+		camera, err := parseCamera(value, "none", "emulated")
+		hw.CameraFront = camera
+		return camera, err
+	},
+	"hw.camera.back": func(hw *Hardware, value string) (string, error) {
+		camera, err := parseCamera(value, "none", "emulated", "virtualscene")
+		hw.CameraBack = camera
+		return camera, err
+	},
+}
+
+func parseMegabytes(value string, min, max int64) (Size, error) {
+	size, err := ParseSize(value, Megabyte)
+	if err != nil {
+		return 0, err
+	}
+	if size%Megabyte != 0 {
+		return 0, fmt.Errorf("invalid size (%s), must be a whole number of megabytes", value)
+	}
+	if size.Megabytes() < min || size.Megabytes() > max {
+		return 0, fmt.Errorf("invalid size (%s), must be between %dM and %dM", value, min, max)
+	}
+	return size, nil
+}
+
+func parseInt(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer (%s)", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("invalid value (%d), must be between %d and %d", n, min, max)
+	}
+	return n, nil
+}
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true":
+		return true, nil
+	case "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean (%s), valid options: yes, no", value)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseEnum compares case-insensitively, like the emulator, and returns the canonical spelling:
+// Android Studio writes values like hw.initialOrientation=Portrait.
+func parseEnum(value string, validValues ...string) (string, error) {
+	for _, v := range validValues {
+		if strings.EqualFold(v, value) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid value (%s), valid options: %s", value, validValues)
+}
+
+func parseCamera(value string, validValues ...string) (string, error) {
+	if strings.HasPrefix(value, "webcam") {
+		if _, err := strconv.Atoi(strings.TrimPrefix(value, "webcam")); err == nil {
+			return value, nil
+		}
+	}
+	camera, err := parseEnum(value, validValues...)
+	if err != nil {
+		return "", fmt.Errorf("invalid camera (%s), valid options: %s or webcam<N>", value, validValues)
+	}
+	return camera, nil
+}
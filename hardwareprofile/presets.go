@@ -0,0 +1,242 @@
+package hardwareprofile
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+// Preset names
+const (
+	PresetPhone      = "phone"
+	PresetSmallPhone = "small-phone"
+	PresetTablet     = "tablet"
+	PresetFoldable   = "foldable"
+	PresetTV         = "tv"
+	PresetWear       = "wear"
+	PresetAutomotive = "automotive"
+	PresetCI         = "ci"
+)
+
+// Presets lists the available preset names.
+var Presets = []string{PresetPhone, PresetSmallPhone, PresetTablet, PresetFoldable, PresetTV, PresetWear, PresetAutomotive, PresetCI}
+
+var presetHardware = map[string][][2]string{
+	PresetPhone: {
+		{"hw.lcd.width", "1080"},
+		{"hw.lcd.height", "1920"},
+		{"hw.lcd.density", "420"},
+		{"hw.ramSize", "2048"},
+		{"vm.heapSize", "256"},
+		{"hw.cpu.ncore", "2"},
+		{"disk.dataPartition.size", "2G"},
+		{"sdcard.size", "512M"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "emulated"},
+		{"hw.camera.front", "emulated"},
+	},
+	PresetSmallPhone: {
+		{"hw.lcd.width", "480"},
+		{"hw.lcd.height", "800"},
+		{"hw.lcd.density", "240"},
+		{"hw.ramSize", "1024"},
+		{"vm.heapSize", "128"},
+		{"hw.cpu.ncore", "2"},
+		{"disk.dataPartition.size", "1G"},
+		{"sdcard.size", "256M"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "emulated"},
+		{"hw.camera.front", "none"},
+	},
+	PresetTablet: {
+		{"hw.lcd.width", "1536"},
+		{"hw.lcd.height", "2048"},
+		{"hw.lcd.density", "320"},
+		{"hw.ramSize", "2048"},
+		{"vm.heapSize", "256"},
+		{"hw.cpu.ncore", "4"},
+		{"disk.dataPartition.size", "4G"},
+		{"sdcard.size", "512M"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "emulated"},
+		{"hw.camera.front", "emulated"},
+	},
+	PresetFoldable: {
+		{"hw.lcd.width", "1768"},
+		{"hw.lcd.height", "2208"},
+		{"hw.lcd.density", "420"},
+		{"hw.ramSize", "2048"},
+		{"vm.heapSize", "256"},
+		{"hw.cpu.ncore", "4"},
+		{"disk.dataPartition.size", "4G"},
+		{"sdcard.size", "512M"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "emulated"},
+		{"hw.camera.front", "emulated"},
+	},
+	PresetTV: {
+		{"hw.lcd.width", "1920"},
+		{"hw.lcd.height", "1080"},
+		{"hw.lcd.density", "320"},
+		{"hw.ramSize", "2048"},
+		{"vm.heapSize", "256"},
+		{"hw.cpu.ncore", "2"},
+		{"disk.dataPartition.size", "2G"},
+		{"hw.keyboard", "yes"},
+		{"hw.dPad", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "none"},
+		{"hw.camera.front", "none"},
+	},
+	PresetWear: {
+		{"hw.lcd.width", "384"},
+		{"hw.lcd.height", "384"},
+		{"hw.lcd.density", "320"},
+		{"hw.ramSize", "512"},
+		{"vm.heapSize", "64"},
+		{"hw.cpu.ncore", "2"},
+		{"disk.dataPartition.size", "1G"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "none"},
+		{"hw.camera.front", "none"},
+	},
+	PresetAutomotive: {
+		{"hw.lcd.width", "1024"},
+		{"hw.lcd.height", "768"},
+		{"hw.lcd.density", "160"},
+		{"hw.ramSize", "2048"},
+		{"vm.heapSize", "256"},
+		{"hw.cpu.ncore", "2"},
+		{"disk.dataPartition.size", "2G"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "auto"},
+		{"hw.camera.back", "none"},
+		{"hw.camera.front", "none"},
+	},
+	PresetCI: {
+		{"hw.lcd.width", "480"},
+		{"hw.lcd.height", "800"},
+		{"hw.lcd.density", "240"},
+		{"hw.ramSize", "1536"},
+		{"vm.heapSize", "256"},
+		{"hw.cpu.ncore", "2"},
+		{"disk.dataPartition.size", "2G"},
+		{"hw.keyboard", "yes"},
+		{"hw.gpu.enabled", "yes"},
+		{"hw.gpu.mode", "swiftshader_indirect"},
+		{"hw.camera.back", "none"},
+		{"hw.camera.front", "none"},
+		{"hw.audioInput", "no"},
+		{"hw.audioOutput", "no"},
+	},
+}
+
+// presetTags lists the system image tags a preset can only be used with.
+var presetTags = map[string]string{
+	PresetTV:   "android-tv",
+	PresetWear: "android-wear",
+}
+
+var cpuArchs = map[string]string{
+	"armeabi-v7a": "arm",
+	"arm64-v8a":   "arm64",
+	"mips":        "mips",
+	"x86":         "x86",
+	"x86_64":      "x86_64",
+}
+
+var tagDisplayNames = map[string]string{
+	"default":               "Default",
+	"google_apis":           "Google APIs",
+	"google_apis_playstore": "Google Play",
+	"android-tv":            "Android TV",
+	"android-wear":          "Android Wear",
+}
+
+// NewPreset creates the named preset profile for the given system image.
+func NewPreset(name string, systemImage sdkcomponent.SystemImage) (Profile, error) {
+	hardware, ok := presetHardware[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown hardware profile preset (%s), valid options: %s", name, Presets)
+	}
+
+	tag := systemImage.Tag
+	if tag == "" {
+		tag = "default"
+	}
+
+	requiredTag, hasRequiredTag := presetTags[name]
+	if hasRequiredTag && tag != requiredTag {
+		return Profile{}, fmt.Errorf("hardware profile preset (%s) requires the %s system image tag, got: %s", name, requiredTag, tag)
+	}
+	if !hasRequiredTag {
+		for preset, t := range presetTags {
+			if tag == t {
+				return Profile{}, fmt.Errorf("hardware profile preset (%s) can not be used with the %s system image tag, use the %s preset", name, tag, preset)
+			}
+		}
+	}
+
+	profile := Profile{}
+	for _, property := range SystemImageProperties(systemImage) {
+		if err := profile.Set(property.Key, property.Value); err != nil {
+			return Profile{}, err
+		}
+	}
+	for _, property := range hardware {
+		if err := profile.Set(property[0], property[1]); err != nil {
+			return Profile{}, err
+		}
+	}
+	return profile, nil
+}
+
+// SystemImageProperties returns the config.ini keys describing the given system image.
+func SystemImageProperties(systemImage sdkcomponent.SystemImage) []Property {
+	tag := systemImage.Tag
+	if tag == "" {
+		tag = "default"
+	}
+
+	playStoreEnabled := "false"
+	if tag == "google_apis_playstore" {
+		playStoreEnabled = "true"
+	}
+
+	tagDisplay := tagDisplayNames[tag]
+	if tagDisplay == "" {
+		tagDisplay = tag
+	}
+
+	return []Property{
+		{Key: "abi.type", Value: systemImage.ABI},
+		{Key: "hw.cpu.arch", Value: cpuArchs[systemImage.ABI]},
+		{Key: "image.sysdir.1", Value: filepath.ToSlash(systemImage.InstallPathInAndroidHome()) + "/"},
+		{Key: "tag.id", Value: tag},
+		{Key: "tag.display", Value: tagDisplay},
+		{Key: "PlayStore.enabled", Value: playStoreEnabled},
+	}
+}
+
+// Merge sets every property of the overrides profile on the profile.
+func (profile *Profile) Merge(overrides Profile) error {
+	for _, property := range overrides.properties {
+		if err := profile.Set(property.Key, property.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package hardwareprofile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+func TestNewPreset(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "hardware-properties.ini"))
+	if err != nil {
+		t.Fatalf("failed to read hardware-properties.ini: %s", err)
+	}
+	definitions, err := ParseDefinitions(string(content))
+	if err != nil {
+		t.Fatalf("ParseDefinitions() error: %s", err)
+	}
+
+	tags := map[string]string{
+		PresetTV:   "android-tv",
+		PresetWear: "android-wear",
+	}
+
+	for _, name := range Presets {
+		tag := tags[name]
+		if tag == "" {
+			tag = "google_apis"
+		}
+		systemImage := sdkcomponent.SystemImage{Platform: "android-28", Tag: tag, ABI: "x86_64"}
+
+		profile, err := NewPreset(name, systemImage)
+		if err != nil {
+			t.Errorf("NewPreset(%s) error: %s", name, err)
+			continue
+		}
+		if len(presetHardware[name]) == 0 {
+			t.Errorf("NewPreset(%s) has no hardware properties", name)
+		}
+		if issues := definitions.Check(profile); len(issues) != 0 {
+			t.Errorf("NewPreset(%s) issues: %v", name, issues)
+		}
+		if conflicts := CheckConsistency(profile, systemImage); len(conflicts) != 0 {
+			t.Errorf("NewPreset(%s) conflicts with its system image: %v", name, conflicts)
+		}
+	}
+}
+
+func TestNewPresetErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		preset    string
+		tag       string
+		wantError string
+	}{
+		{name: "unknown preset", preset: "watch", tag: "default", wantError: "unknown hardware profile preset (watch)"},
+		{name: "empty preset", preset: "", tag: "default", wantError: "unknown hardware profile preset ()"},
+		{name: "tv preset without tv image", preset: PresetTV, tag: "google_apis", wantError: "requires the android-tv system image tag"},
+		{name: "phone preset with wear image", preset: PresetPhone, tag: "android-wear", wantError: "use the wear preset"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewPreset(tt.preset, sdkcomponent.SystemImage{Platform: "android-28", Tag: tt.tag, ABI: "x86"})
+		if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+			t.Errorf("%s: NewPreset() error = %v, want %s", tt.name, err, tt.wantError)
+		}
+	}
+}
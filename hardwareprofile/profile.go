@@ -0,0 +1,189 @@
+package hardwareprofile
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Property is a single key=value entry of a hardware profile.
+type Property struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// Profile is an AVD hardware profile (the content of the AVD's config.ini).
+// The properties keep their original order, values of the known hardware
+// keys are normalized.
+type Profile struct {
+	properties []Property
+	hardware   Hardware
+}
+
+// LineError is an issue found on a given line of the hardware profile.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+// Error ...
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Errors collects every issue found while parsing a hardware profile.
+type Errors []LineError
+
+// Error ...
+func (errs Errors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Parse parses and validates the hardware profile content.
+// Empty lines and lines starting with # or ; are skipped.
+// Every malformed line and invalid value is reported in the returned Errors.
+func Parse(content string) (Profile, error) {
+	return parse(content, true)
+}
+
+// ParseLenient parses the hardware profile content without validating the values,
+// for config.ini files written by avdmanager, which can hold values out of the ranges accepted by Parse
+// (like the hw.ramSize of small device definitions). Invalid values are kept as-is
+// and left out of the typed Hardware view; malformed lines are still reported.
+func ParseLenient(content string) (Profile, error) {
+	return parse(content, false)
+}
+
+func parse(content string, strict bool) (Profile, error) {
+	profile := Profile{}
+	errs := Errors{}
+	definedAt := map[string]int{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 {
+			errs = append(errs, LineError{lineNum, fmt.Errorf("expected key=value, got: %s", line)})
+			continue
+		}
+
+		key := strings.TrimSpace(split[0])
+		value := strings.TrimSpace(split[1])
+		if key == "" {
+			errs = append(errs, LineError{lineNum, fmt.Errorf("missing key in: %s", line)})
+			continue
+		}
+		if strings.IndexFunc(key, unicode.IsSpace) != -1 {
+			errs = append(errs, LineError{lineNum, fmt.Errorf("invalid key (%s), must not contain whitespace", key)})
+			continue
+		}
+		if firstLine, ok := definedAt[key]; ok {
+			errs = append(errs, LineError{lineNum, fmt.Errorf("duplicated key (%s), first defined on line %d", key, firstLine)})
+			continue
+		}
+		definedAt[key] = lineNum
+
+		normalized, err := profile.decode(key, value)
+		if err != nil && !strict {
+			normalized = value
+		} else if err != nil {
+			errs = append(errs, LineError{lineNum, fmt.Errorf("%s: %s", key, err)})
+			continue
+		}
+
+		profile.properties = append(profile.properties, Property{Key: key, Value: normalized, Line: lineNum})
+	}
+	if err := scanner.Err(); err != nil {
+		return Profile{}, err
+	}
+
+	if len(errs) > 0 {
+		return Profile{}, errs
+	}
+	return profile, nil
+}
+
+func (profile *Profile) decode(key, value string) (string, error) {
+	decode, ok := decoders[key]
+	if !ok {
+		return value, nil
+	}
+
+	hardware := profile.hardware
+	normalized, err := decode(&hardware, value)
+	if err != nil {
+		return "", err
+	}
+	profile.hardware = hardware
+	return normalized, nil
+}
+
+// Hardware returns the typed view of the known hardware keys.
+func (profile Profile) Hardware() Hardware {
+	return profile.hardware
+}
+
+// Properties returns the profile entries in their original order.
+func (profile Profile) Properties() []Property {
+	return append([]Property{}, profile.properties...)
+}
+
+// Get returns the value of the given key.
+func (profile Profile) Get(key string) (string, bool) {
+	for _, property := range profile.properties {
+		if property.Key == key {
+			return property.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set validates and sets the value of the given key,
+// new keys are appended to the end of the profile.
+func (profile *Profile) Set(key, value string) error {
+	normalized, err := profile.decode(key, value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+
+	for i, property := range profile.properties {
+		if property.Key == key {
+			profile.properties[i].Value = normalized
+			return nil
+		}
+	}
+
+	profile.properties = append(profile.properties, Property{Key: key, Value: normalized})
+	return nil
+}
+
+// Delete removes the given key from the profile.
+func (profile *Profile) Delete(key string) {
+	var properties []Property
+	for _, property := range profile.properties {
+		if property.Key != key {
+			properties = append(properties, property)
+		}
+	}
+	profile.properties = properties
+}
+
+// String returns the profile in config.ini format.
+func (profile Profile) String() string {
+	var b strings.Builder
+	for _, property := range profile.properties {
+		fmt.Fprintf(&b, "%s=%s\n", property.Key, property.Value)
+	}
+	return b.String()
+}
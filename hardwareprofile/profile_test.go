@@ -0,0 +1,159 @@
+package hardwareprofile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := `# comment
+; comment
+
+hw.ramSize = 2G
+hw.lcd.width=1080
+disk.dataPartition.size=2048M
+hw.gpu.mode=Host
+hw.keyboard=true
+hw.camera.back=webcam0
+sdcard.size=512M
+custom.key=value with spaces
+`
+
+	profile, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+
+	wantHardware := Hardware{
+		RAMSize:           2 * Gigabyte,
+		LCDWidth:          1080,
+		DataPartitionSize: 2 * Gigabyte,
+		GPUMode:           "host",
+		Keyboard:          true,
+		CameraBack:        "webcam0",
+		SDCardSize:        512 * Megabyte,
+	}
+	if got := profile.Hardware(); got != wantHardware {
+		t.Errorf("Hardware() = %+v, want %+v", got, wantHardware)
+	}
+
+	wantProperties := []Property{
+		{Key: "hw.ramSize", Value: "2048", Line: 4},
+		{Key: "hw.lcd.width", Value: "1080", Line: 5},
+		{Key: "disk.dataPartition.size", Value: "2G", Line: 6},
+		{Key: "hw.gpu.mode", Value: "host", Line: 7},
+		{Key: "hw.keyboard", Value: "yes", Line: 8},
+		{Key: "hw.camera.back", Value: "webcam0", Line: 9},
+		{Key: "sdcard.size", Value: "512M", Line: 10},
+		{Key: "custom.key", Value: "value with spaces", Line: 11},
+	}
+	if got := profile.Properties(); !reflect.DeepEqual(got, wantProperties) {
+		t.Errorf("Properties() = %+v, want %+v", got, wantProperties)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantLines []int
+	}{
+		{name: "missing separator", content: "hw.ramSize=2048\nhw.keyboard\n", wantLines: []int{2}},
+		{name: "missing key", content: "=yes\n", wantLines: []int{1}},
+		{name: "whitespace in key", content: "hw ramSize=2048\n", wantLines: []int{1}},
+		{name: "duplicated key", content: "hw.ramSize=2048\n\nhw.ramSize=1024\n", wantLines: []int{3}},
+		{name: "ram out of range", content: "hw.ramSize=64\n", wantLines: []int{1}},
+		{name: "ram not whole megabytes", content: "hw.ramSize=1536K\n", wantLines: []int{1}},
+		{name: "invalid integer", content: "hw.lcd.density=high\n", wantLines: []int{1}},
+		{name: "invalid boolean", content: "hw.keyboard=maybe\n", wantLines: []int{1}},
+		{name: "invalid enum", content: "hw.gpu.mode=fast\n", wantLines: []int{1}},
+		{name: "invalid camera", content: "hw.camera.front=virtualscene\n", wantLines: []int{1}},
+		{name: "data partition overflow", content: "disk.dataPartition.size=99999999999G\n", wantLines: []int{1}},
+		{name: "sdcard too small", content: "sdcard.size=1M\n", wantLines: []int{1}},
+		{name: "every error reported", content: "hw.ramSize=1\nok.key=1\nbad line\nhw.cpu.ncore=0\n", wantLines: []int{1, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.content)
+		errs, ok := err.(Errors)
+		if !ok {
+			t.Errorf("%s: Parse() error = %v, want Errors", tt.name, err)
+			continue
+		}
+
+		var lines []int
+		for _, lineErr := range errs {
+			lines = append(lines, lineErr.Line)
+		}
+		if !reflect.DeepEqual(lines, tt.wantLines) {
+			t.Errorf("%s: error lines = %v, want %v (%s)", tt.name, lines, tt.wantLines, errs)
+		}
+	}
+}
+
+func TestErrorsError(t *testing.T) {
+	_, err := Parse("hw.keyboard\nhw.ramSize=1\n")
+	want := "line 1: expected key=value, got: hw.keyboard\nline 2: hw.ramSize: invalid size (1), must be between 128M and 65536M"
+	if err == nil || err.Error() != want {
+		t.Errorf("Error() = %v, want %s", err, want)
+	}
+}
+
+func TestProfileSetDelete(t *testing.T) {
+	profile, err := Parse("hw.ramSize=2048\nhw.keyboard=no\n")
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+
+	if err := profile.Set("hw.ramSize", "4G"); err != nil {
+		t.Fatalf("Set() error: %s", err)
+	}
+	if err := profile.Set("hw.lcd.height", "1920"); err != nil {
+		t.Fatalf("Set() error: %s", err)
+	}
+	if err := profile.Set("hw.lcd.height", "1"); err == nil {
+		t.Errorf("Set() with out of range value, want error")
+	}
+	profile.Delete("hw.keyboard")
+
+	want := "hw.ramSize=4096\nhw.lcd.height=1920\n"
+	if got := profile.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := profile.Hardware().RAMSize; got != 4*Gigabyte {
+		t.Errorf("RAMSize = %d, want %d", got, 4*Gigabyte)
+	}
+	if _, ok := profile.Get("hw.keyboard"); ok {
+		t.Errorf("Get(hw.keyboard) found a deleted key")
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	content := "hw.ramSize=96\nhw.lcd.width=1080\nhw.lcd.density=80\n"
+
+	if _, err := Parse(content); err == nil {
+		t.Errorf("Parse() with out of range values, want error")
+	}
+
+	profile, err := ParseLenient(content)
+	if err != nil {
+		t.Fatalf("ParseLenient() error: %s", err)
+	}
+	if got := profile.String(); got != content {
+		t.Errorf("String() = %q, want %q", got, content)
+	}
+	if want := (Hardware{LCDWidth: 1080}); profile.Hardware() != want {
+		t.Errorf("Hardware() = %+v, want %+v", profile.Hardware(), want)
+	}
+
+	if err := profile.Merge(Profile{properties: []Property{{Key: "hw.ramSize", Value: "1"}}}); err == nil {
+		t.Errorf("Merge() with out of range override, want error")
+	}
+	if err := profile.Merge(Profile{properties: []Property{{Key: "hw.ramSize", Value: "512"}}}); err != nil {
+		t.Errorf("Merge() error: %s", err)
+	}
+
+	if _, err := ParseLenient("hw.keyboard\n"); err == nil {
+		t.Errorf("ParseLenient() with malformed line, want error")
+	}
+}
@@ -0,0 +1,79 @@
+package hardwareprofile
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a storage or memory size in bytes.
+type Size int64
+
+// Size units
+const (
+	Byte     Size = 1
+	Kilobyte      = 1024 * Byte
+	Megabyte      = 1024 * Kilobyte
+	Gigabyte      = 1024 * Megabyte
+)
+
+// ParseSize parses sizes like 512, 512M, 2G or 2GB.
+// Values without a unit suffix are interpreted in the given defaultUnit.
+func ParseSize(value string, defaultUnit Size) (Size, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+
+	unit := defaultUnit
+	if strings.HasSuffix(s, "B") {
+		s = strings.TrimSuffix(s, "B")
+		unit = Byte
+	}
+
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			unit, s = Kilobyte, s[:n-1]
+		case 'M':
+			unit, s = Megabyte, s[:n-1]
+		case 'G':
+			unit, s = Gigabyte, s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size (%s), expected a number optionally followed by K, M or G", value)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid size (%s), must not be negative", value)
+	}
+	if n > math.MaxInt64/int64(unit) {
+		return 0, fmt.Errorf("invalid size (%s), too large", value)
+	}
+
+	return Size(n) * unit, nil
+}
+
+// Megabytes returns the size in whole megabytes.
+func (s Size) Megabytes() int64 {
+	return int64(s / Megabyte)
+}
+
+// String returns the size in the largest unit that represents it exactly.
+func (s Size) String() string {
+	switch {
+	case s == 0:
+		return "0"
+	case s%Gigabyte == 0:
+		return fmt.Sprintf("%dG", s/Gigabyte)
+	case s%Megabyte == 0:
+		return fmt.Sprintf("%dM", s/Megabyte)
+	case s%Kilobyte == 0:
+		return fmt.Sprintf("%dK", s/Kilobyte)
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
@@ -0,0 +1,66 @@
+package hardwareprofile
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value       string
+		defaultUnit Size
+		want        Size
+		wantErr     bool
+	}{
+		{value: "512", defaultUnit: Megabyte, want: 512 * Megabyte},
+		{value: "512", defaultUnit: Byte, want: 512},
+		{value: "512M", defaultUnit: Byte, want: 512 * Megabyte},
+		{value: "2G", defaultUnit: Byte, want: 2 * Gigabyte},
+		{value: "2GB", defaultUnit: Byte, want: 2 * Gigabyte},
+		{value: "64k", defaultUnit: Byte, want: 64 * Kilobyte},
+		{value: " 1g ", defaultUnit: Byte, want: Gigabyte},
+		{value: "100B", defaultUnit: Megabyte, want: 100},
+		{value: "0", defaultUnit: Megabyte, want: 0},
+		{value: "", defaultUnit: Byte, wantErr: true},
+		{value: "G", defaultUnit: Byte, wantErr: true},
+		{value: "1.5G", defaultUnit: Byte, wantErr: true},
+		{value: "-1M", defaultUnit: Byte, wantErr: true},
+		{value: "2T", defaultUnit: Byte, wantErr: true},
+		{value: "99999999999G", defaultUnit: Byte, wantErr: true},
+		{value: "9223372036854775807", defaultUnit: Byte, want: 9223372036854775807},
+		{value: "9223372036854775807", defaultUnit: Kilobyte, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value, tt.defaultUnit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSize(%q) error: %s", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	tests := []struct {
+		size Size
+		want string
+	}{
+		{size: 0, want: "0"},
+		{size: 2 * Gigabyte, want: "2G"},
+		{size: 1536 * Megabyte, want: "1536M"},
+		{size: 3 * Kilobyte, want: "3K"},
+		{size: 1000, want: "1000"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.size.String(); got != tt.want {
+			t.Errorf("Size(%d).String() = %s, want %s", int64(tt.size), got, tt.want)
+		}
+	}
+}
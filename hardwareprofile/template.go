@@ -0,0 +1,45 @@
+package hardwareprofile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// TemplateData holds the values available in a hardware profile template,
+// like {{.ABI}} or {{.SystemImageDir}}.
+type TemplateData struct {
+	Name           string
+	Platform       string
+	ABI            string
+	Tag            string
+	SystemImageDir string
+	AndroidHome    string
+}
+
+var templateFuncs = template.FuncMap{
+	"env": func(key string) (string, error) {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return "", fmt.Errorf("environment variable (%s) is not set", key)
+		}
+		return value, nil
+	},
+}
+
+// Expand executes the hardware profile content as a text/template.
+// Besides the TemplateData fields, environment variables can be referenced as {{env "NAME"}}.
+// Unknown fields and unset environment variables are reported as errors.
+func Expand(content string, data TemplateData) (string, error) {
+	tmpl, err := template.New("hardware profile").Funcs(templateFuncs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,62 @@
+package hardwareprofile
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	if err := os.Setenv("HARDWARE_PROFILE_TEST_RAM", "2048"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Unsetenv("HARDWARE_PROFILE_TEST_RAM")
+	}()
+
+	data := TemplateData{
+		Name:           "test",
+		Platform:       "android-28",
+		ABI:            "x86_64",
+		Tag:            "google_apis",
+		SystemImageDir: "system-images/android-28/google_apis/x86_64/",
+		AndroidHome:    "/opt/android-sdk",
+	}
+
+	tests := []struct {
+		name      string
+		content   string
+		want      string
+		wantError string
+	}{
+		{name: "no template", content: "hw.ramSize=1024\n", want: "hw.ramSize=1024\n"},
+		{
+			name:    "fields",
+			content: "avd.name={{.Name}}\nabi.type={{.ABI}}\ntag.id={{.Tag}}\nimage.sysdir.1={{.SystemImageDir}}\nskin.path={{.AndroidHome}}/platforms/{{.Platform}}/skins\n",
+			want:    "avd.name=test\nabi.type=x86_64\ntag.id=google_apis\nimage.sysdir.1=system-images/android-28/google_apis/x86_64/\nskin.path=/opt/android-sdk/platforms/android-28/skins\n",
+		},
+		{name: "environment variable", content: `hw.ramSize={{env "HARDWARE_PROFILE_TEST_RAM"}}`, want: "hw.ramSize=2048"},
+		{name: "escaped braces", content: `skin.name={{"{{"}}skin{{"}}"}}`, want: "skin.name={{skin}}"},
+		{name: "undefined field", content: "abi.type={{.Abi}}", wantError: "can't evaluate field Abi"},
+		{name: "unset environment variable", content: `hw.ramSize={{env "HARDWARE_PROFILE_TEST_UNSET"}}`, wantError: "environment variable (HARDWARE_PROFILE_TEST_UNSET) is not set"},
+		{name: "unknown function", content: `hw.ramSize={{ram}}`, wantError: `function "ram" not defined`},
+		{name: "unclosed action", content: "abi.type={{.ABI", wantError: "unclosed action"},
+	}
+
+	for _, tt := range tests {
+		got, err := Expand(tt.content, data)
+		if tt.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("%s: Expand() error = %v, want %s", tt.name, err, tt.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Expand() error: %s", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Expand() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
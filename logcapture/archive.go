@@ -0,0 +1,72 @@
+package logcapture
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Archive compresses the log files of the capture dir into a tar.gz archive,
+// and returns the names of the archived files.
+func Archive(dir, archivePth string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(archivePth)
+	if err != nil {
+		return nil, err
+	}
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	var names []string
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || info.Name() == configFileName || info.Name() == pidFileName {
+			continue
+		}
+
+		if err := addFile(tw, filepath.Join(dir, info.Name()), info); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		names = append(names, info.Name())
+	}
+
+	if err := tw.Close(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return names, f.Close()
+}
+
+func addFile(tw *tar.Writer, pth string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
@@ -0,0 +1,108 @@
+package logcapture
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	dir := filepath.Join(tmpDir, "logs")
+	files := map[string]string{
+		EmulatorLogName:                        "emulator output",
+		rotatedPath(EmulatorLogName, 1):        "older emulator output",
+		LogcatLogName:                          "logcat output",
+		captureLogName:                         "",
+		configFileName:                         "{}",
+		pidFileName:                            "123",
+		filepath.Join("nested", LogcatLogName): "not archived",
+	}
+	for name, content := range files {
+		pth := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pth, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePth := filepath.Join(tmpDir, "logs.tar.gz")
+	names, err := Archive(dir, archivePth)
+	if err != nil {
+		t.Fatalf("Archive() error: %s", err)
+	}
+
+	want := []string{captureLogName, EmulatorLogName, rotatedPath(EmulatorLogName, 1), LogcatLogName}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Archive() = %v, want %v", names, want)
+	}
+
+	f, err := os.Open(archivePth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("invalid gzip archive: %s", err)
+	}
+
+	archived := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid tar archive: %s", err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archived[header.Name] = string(content)
+	}
+
+	for _, name := range want {
+		if archived[name] != files[name] {
+			t.Errorf("archived %s = %q, want %q", name, archived[name], files[name])
+		}
+	}
+	if len(archived) != len(want) {
+		t.Errorf("archived %d files, want %d", len(archived), len(want))
+	}
+}
+
+func TestArchiveMissingDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	archivePth := filepath.Join(tmpDir, "logs.tar.gz")
+	if _, err := Archive(filepath.Join(tmpDir, "missing"), archivePth); err == nil {
+		t.Errorf("Archive() error = nil, want error")
+	}
+	if _, err := os.Stat(archivePth); !os.IsNotExist(err) {
+		t.Errorf("archive created for a missing dir")
+	}
+}
@@ -0,0 +1,262 @@
+package logcapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// CaptureArg is the argument the step binary is re-executed with to run the capture process.
+const CaptureArg = "capture-logs"
+
+// Files of the capture dir
+const (
+	EmulatorLogName = "emulator.log"
+	LogcatLogName   = "logcat.log"
+	captureLogName  = "capture.log"
+	configFileName  = "capture.json"
+	pidFileName     = "capture.pid"
+)
+
+const logcatRestartDelay = 2 * time.Second
+
+// Config describes what the capture process records.
+type Config struct {
+	Dir      string   `json:"dir"`
+	ADBPath  string   `json:"adb_path"`
+	Serial   string   `json:"serial"`
+	Buffers  []string `json:"buffers"`
+	Filters  []string `json:"filters"`
+	MaxSize  int64    `json:"max_size"`
+	MaxFiles int      `json:"max_files"`
+}
+
+func (cfg Config) logcatArgs() []string {
+	args := []string{"-s", cfg.Serial, "logcat", "-v", "threadtime"}
+	for _, buffer := range cfg.Buffers {
+		args = append(args, "-b", buffer)
+	}
+	return append(args, cfg.Filters...)
+}
+
+// Start launches the capture process in its own session, so it keeps running after the step exits.
+// The returned file is the write end of the pipe recorded into emulator.log:
+// it should be set as the emulator's stdout and stderr, and closed once the emulator is started.
+func Start(executable string, cfg Config) (*os.File, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfgPth := filepath.Join(cfg.Dir, configFileName)
+	if err := fileutil.WriteBytesToFile(cfgPth, b); err != nil {
+		return nil, err
+	}
+
+	captureLog, err := os.OpenFile(filepath.Join(cfg.Dir, captureLogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = captureLog.Close()
+	}()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	cmd := exec.Command(executable, CaptureArg, cfgPth)
+	cmd.Stdout = captureLog
+	cmd.Stderr = captureLog
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("failed to start log capture, error: %s", err)
+	}
+
+	// reap the capture process if it exits while the step is running, so it does not linger as a zombie
+	go func() {
+		_ = cmd.Wait()
+	}()
+	return w, nil
+}
+
+// Run is the body of the capture process started by Start:
+// it records the emulator output (inherited as fd 3) and logcat until it receives SIGTERM or SIGINT.
+func Run(cfgPth string) error {
+	b, err := ioutil.ReadFile(cfgPth)
+	if err != nil {
+		return err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return err
+	}
+
+	if err := fileutil.WriteStringToFile(filepath.Join(cfg.Dir, pidFileName), strconv.Itoa(os.Getpid())); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(filepath.Join(cfg.Dir, pidFileName))
+	}()
+
+	emulatorLog, err := NewRotatingWriter(filepath.Join(cfg.Dir, EmulatorLogName), cfg.MaxSize, cfg.MaxFiles)
+	if err != nil {
+		return err
+	}
+	logcatLog, err := NewRotatingWriter(filepath.Join(cfg.Dir, LogcatLogName), cfg.MaxSize, cfg.MaxFiles)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		if _, err := io.Copy(emulatorLog, os.NewFile(3, "emulator-output")); err != nil && err != ErrClosed {
+			fmt.Printf("emulator output capture stopped: %s\n", err)
+		}
+	}()
+
+	l := &logcat{cfg: cfg, w: logcatLog}
+	go l.run()
+
+	<-signals
+
+	l.stop()
+
+	var errs []string
+	for _, w := range []*RotatingWriter{emulatorLog, logcatLog} {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close log files: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// logcat keeps logcat running, it is restarted when the device disconnects.
+type logcat struct {
+	cfg Config
+	w   io.Writer
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+}
+
+func (l *logcat) run() {
+	for {
+		if !l.exec(exec.Command(l.cfg.ADBPath, "-s", l.cfg.Serial, "wait-for-device")) {
+			return
+		}
+		if !l.exec(exec.Command(l.cfg.ADBPath, l.cfg.logcatArgs()...)) {
+			return
+		}
+		time.Sleep(logcatRestartDelay)
+	}
+}
+
+// exec runs the adb command with its output recorded, returns false if the capture was stopped.
+func (l *logcat) exec(cmd *exec.Cmd) bool {
+	cmd.Stdout = l.w
+	cmd.Stderr = l.w
+
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return false
+	}
+	if err := cmd.Start(); err != nil {
+		l.mu.Unlock()
+		fmt.Printf("failed to start %s: %s\n", strings.Join(cmd.Args, " "), err)
+		time.Sleep(logcatRestartDelay)
+		return true
+	}
+	l.cmd = cmd
+	l.mu.Unlock()
+
+	if err := cmd.Wait(); err != nil {
+		fmt.Printf("%s exited: %s\n", strings.Join(cmd.Args, " "), err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cmd = nil
+	return !l.stopped
+}
+
+func (l *logcat) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopped = true
+	if l.cmd != nil && l.cmd.Process != nil {
+		_ = l.cmd.Process.Signal(syscall.SIGTERM)
+	}
+}
+
+// Stop signals the capture process recording into the dir and waits until it flushes and closes the log files,
+// it is killed after the timeout. Returns false if no capture process was running.
+// The capture process removes its pid file once the log files are closed, that is waited for,
+// the process itself may not be reaped yet if it was started by the calling process.
+func Stop(dir string, timeout time.Duration) (bool, error) {
+	pidPth := filepath.Join(dir, pidFileName)
+	content, err := ioutil.ReadFile(pidPth)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return false, fmt.Errorf("invalid pid file: %s", err)
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGTERM); err == syscall.ESRCH {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(pidPth); os.IsNotExist(err) {
+			return true, nil
+		}
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return true, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return true, err
+	}
+	return true, fmt.Errorf("log capture did not stop in %s, killed", timeout)
+}
@@ -0,0 +1,119 @@
+package logcapture
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startFakeCapture starts a shell standing in for the capture process: it writes its pid file,
+// and on SIGTERM removes it and exits, or ignores the signal if ignoreTerm is set.
+// The process is not waited for, like the capture process started by the step.
+func startFakeCapture(t *testing.T, dir string, ignoreTerm bool) *exec.Cmd {
+	pidPth := filepath.Join(dir, pidFileName)
+	trap := "trap 'rm -f " + pidPth + "; exit 0' TERM"
+	if ignoreTerm {
+		trap = "trap '' TERM"
+	}
+
+	cmd := exec.Command("sh", "-c", trap+"; echo $$ > "+pidPth+".tmp; mv "+pidPth+".tmp "+pidPth+"; while :; do sleep 0.05; done")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start the fake capture process: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(pidPth); err == nil {
+			return cmd
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = cmd.Process.Kill()
+	t.Fatalf("the fake capture process did not write its pid file")
+	return nil
+}
+
+func TestStop(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	cmd := startFakeCapture(t, tmpDir, false)
+	defer func() {
+		_ = cmd.Wait()
+	}()
+
+	start := time.Now()
+	stopped, err := Stop(tmpDir, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Stop() error: %s", err)
+	}
+	if !stopped {
+		t.Errorf("Stop() = false, want the running capture stopped")
+	}
+	// the exited process is a zombie until it is waited for, Stop must not wait for the timeout
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stop() took %s, want it to return once the pid file is removed", elapsed)
+	}
+}
+
+func TestStopKillsAfterTimeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	cmd := startFakeCapture(t, tmpDir, true)
+
+	stopped, err := Stop(tmpDir, 300*time.Millisecond)
+	if !stopped || err == nil {
+		t.Errorf("Stop() = %v, %v, want the capture killed after the timeout", stopped, err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Errorf("the capture process was not killed")
+	}
+}
+
+func TestStopNotRunning(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if stopped, err := Stop(tmpDir, time.Second); stopped || err != nil {
+		t.Errorf("Stop() without pid file = %v, %v, want false, nil", stopped, err)
+	}
+
+	// a stale pid file of an exited process
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, pidFileName), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stopped, err := Stop(tmpDir, time.Second); stopped || err != nil {
+		t.Errorf("Stop() with a stale pid file = %v, %v, want false, nil", stopped, err)
+	}
+}
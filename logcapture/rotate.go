@@ -0,0 +1,114 @@
+package logcapture
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrClosed is returned by writes after the writer was closed.
+var ErrClosed = errors.New("log file closed")
+
+// RotatingWriter writes into a file, which is rotated once it reaches MaxSize:
+// file.log is renamed to file.log.1, file.log.1 to file.log.2 and so on, keeping at most MaxFiles rotated files.
+// It is safe for concurrent use.
+type RotatingWriter struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// NewRotatingWriter opens (appends to) the file at the given path.
+func NewRotatingWriter(pth string, maxSize int64, maxFiles int) (*RotatingWriter, error) {
+	w := &RotatingWriter{Path: pth, MaxSize: maxSize, MaxFiles: maxFiles}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// Write ...
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	for i := w.MaxFiles - 1; i > 0; i-- {
+		if err := os.Rename(rotatedPath(w.Path, i), rotatedPath(w.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if w.MaxFiles > 0 {
+		if err := os.Rename(w.Path, rotatedPath(w.Path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.Path); err != nil {
+		return err
+	}
+
+	return w.open()
+}
+
+func rotatedPath(pth string, i int) string {
+	return fmt.Sprintf("%s.%d", pth, i)
+}
+
+// Close flushes the file to disk and closes it, subsequent writes fail with ErrClosed.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,141 @@
+package logcapture
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readLogs returns the content of the log file and of its rotated files, "-" if a file does not exist.
+func readLogs(t *testing.T, pth string, rotated int) []string {
+	var contents []string
+	for i := 0; i <= rotated; i++ {
+		filePth := pth
+		if i > 0 {
+			filePth = rotatedPath(pth, i)
+		}
+
+		content, err := ioutil.ReadFile(filePth)
+		if os.IsNotExist(err) {
+			contents = append(contents, "-")
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(content))
+	}
+	return contents
+}
+
+func TestRotatingWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFiles int
+		writes   []string
+		want     []string
+	}{
+		{
+			name:     "below max size",
+			maxFiles: 2,
+			writes:   []string{"01234", "56789"},
+			want:     []string{"0123456789", "-", "-", "-"},
+		},
+		{
+			name:     "rotated at max size",
+			maxFiles: 2,
+			writes:   []string{"0123456789", "abcde", "fghij"},
+			want:     []string{"abcdefghij", "0123456789", "-", "-"},
+		},
+		{
+			name:     "oldest file dropped at max files",
+			maxFiles: 2,
+			writes:   []string{"0123456789", "abcdefghij", "klm", "nopqrstuvw"},
+			want:     []string{"nopqrstuvw", "klm", "abcdefghij", "-"},
+		},
+		{
+			name:     "write larger than max size",
+			maxFiles: 2,
+			writes:   []string{"0123456789abcdef", "ghi"},
+			want:     []string{"ghi", "0123456789abcdef", "-", "-"},
+		},
+		{
+			name:     "no rotated files kept",
+			maxFiles: 0,
+			writes:   []string{"0123456789", "abcde"},
+			want:     []string{"abcde", "-", "-", "-"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "rotate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(tmpDir)
+			}()
+
+			pth := filepath.Join(tmpDir, "emulator.log")
+			w, err := NewRotatingWriter(pth, 10, tt.maxFiles)
+			if err != nil {
+				t.Fatalf("NewRotatingWriter() error: %s", err)
+			}
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%s) = %d, %v, want %d", s, n, err, len(s))
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error: %s", err)
+			}
+
+			got := readLogs(t, pth, 3)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("log files = %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingWriterAppends(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	pth := filepath.Join(tmpDir, "logcat.log")
+	if err := ioutil.WriteFile(pth, []byte("01234567"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the existing content counts towards the max size
+	w, err := NewRotatingWriter(pth, 10, 1)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter() error: %s", err)
+	}
+	if _, err := w.Write([]byte("89a")); err != nil {
+		t.Fatalf("Write() error: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %s", err)
+	}
+
+	if got, want := readLogs(t, pth, 2), []string{"89a", "01234567", "-"}; got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("log files = %q, want %q", got, want)
+	}
+
+	if _, err := w.Write([]byte("b")); err != ErrClosed {
+		t.Errorf("Write() after Close() error = %v, want %s", err, ErrClosed)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close() error: %s", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/exporter"
+	"github.com/bitrise-steplib/steps-create-android-emulator/logcapture"
+	"github.com/kballard/go-shellquote"
+)
+
+// Step modes
+const (
+	modeCreate      = "create"
+	modeCollectLogs = "collect_logs"
+	modeImportAVD   = "import_avd"
+)
+
+var modes = []string{modeCreate, modeCollectLogs, modeImportAVD}
+
+const collectLogsReportFileName = "create-android-emulator-collect-logs-report.json"
+
+// logsDir returns the dir the logs of the AVD are captured into, in the deploy dir.
+func logsDir(name string) string {
+	return deployPath(name + "-logs")
+}
+
+func (configs ConfigsModel) validateLogCapture() error {
+	if !isValueValid(configs.CaptureLogs, []string{"yes", "no"}) {
+		return fmt.Errorf("invalid CaptureLogs parameter specified (%s), valid options: [yes no]", configs.CaptureLogs)
+	} else if configs.CaptureLogs == "no" {
+		return nil
+	}
+
+	if configs.Boot != "yes" {
+		return errors.New("CaptureLogs requires Boot to be yes")
+	}
+
+	if _, err := shellquote.Split(configs.LogcatFilters); err != nil {
+		return fmt.Errorf("invalid LogcatFilters parameter specified (%s), error: %s", configs.LogcatFilters, err)
+	}
+
+	if size, err := strconv.Atoi(configs.LogRotateSize); err != nil || size <= 0 {
+		return fmt.Errorf("invalid LogRotateSize parameter specified (%s), should be a positive number of megabytes", configs.LogRotateSize)
+	}
+
+	if count, err := strconv.Atoi(configs.LogRotateCount); err != nil || count < 0 {
+		return fmt.Errorf("invalid LogRotateCount parameter specified (%s), should be a non-negative number", configs.LogRotateCount)
+	}
+
+	return nil
+}
+
+// logCaptureConfig returns the capture config of the validated inputs, the adb path and the serial are set on boot.
+func (configs ConfigsModel) logCaptureConfig() *logcapture.Config {
+	var buffers []string
+	for _, buffer := range strings.Split(configs.LogcatBuffers, ",") {
+		if buffer = strings.TrimSpace(buffer); buffer != "" {
+			buffers = append(buffers, buffer)
+		}
+	}
+
+	filters, _ := shellquote.Split(configs.LogcatFilters)
+	size, _ := strconv.Atoi(configs.LogRotateSize)
+	count, _ := strconv.Atoi(configs.LogRotateCount)
+
+	return &logcapture.Config{
+		Dir:      logsDir(configs.Name),
+		Buffers:  buffers,
+		Filters:  filters,
+		MaxSize:  int64(size) * 1024 * 1024,
+		MaxFiles: count,
+	}
+}
+
+// collectLogs stops the log capture of the AVD, compresses the captured logs and exports their paths.
+func collectLogs(name string, envExporter exporter.Exporter) error {
+	runReport.startPhase("stop_log_capture")
+	log.Printf("")
+	log.Infof("Stopping log capture")
+
+	dir := logsDir(name)
+	if exist, err := pathutil.IsDirExists(dir); err != nil {
+		return fmt.Errorf("failed to check if the logs dir (%s) exists, error: %s", dir, err)
+	} else if !exist {
+		return fmt.Errorf("no captured logs found at: %s, was the emulator booted with capture_logs: yes?", dir)
+	}
+
+	if running, err := logcapture.Stop(dir, logCaptureStopTimeout); err != nil {
+		log.Warnf("%s", err)
+	} else if !running {
+		log.Warnf("Log capture was not running")
+	} else {
+		log.Donef("Log capture stopped")
+	}
+
+	runReport.startPhase("archive_logs")
+	log.Printf("")
+	log.Infof("Compressing logs")
+
+	archivePth := deployPath(name + "-logs.tar.gz")
+	files, err := logcapture.Archive(dir, archivePth)
+	if err != nil {
+		return fmt.Errorf("failed to compress logs, error: %s", err)
+	}
+	for _, file := range files {
+		log.Printf("- %s", file)
+	}
+
+	runReport.startPhase("export")
+	log.Printf("")
+	log.Infof("Exporting outputs")
+
+	for _, output := range [][2]string{
+		{bitriseEmulatorLogsDir, dir},
+		{bitriseEmulatorLogsArchivePath, archivePth},
+	} {
+		if err := envExporter.Export(output[0], output[1]); err != nil {
+			return newStepError(errExportFailed, err, "failed to export %s", output[0])
+		}
+		log.Printf("%s: %s", output[0], output[1])
+	}
+	return nil
+}
@@ -4,22 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
+	"sync"
 
-	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
-	"github.com/bitrise-io/go-utils/pathutil"
-	"github.com/bitrise-tools/go-android/avdmanager"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+	"github.com/bitrise-steplib/steps-create-android-emulator/exporter"
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-steplib/steps-create-android-emulator/logcapture"
 	"github.com/bitrise-tools/go-android/sdk"
-	"github.com/bitrise-tools/go-android/sdkcomponent"
-	"github.com/bitrise-tools/go-android/sdkmanager"
-	"github.com/bitrise-tools/go-steputils/tools"
-	"github.com/kballard/go-shellquote"
-)
-
-const (
-	bitriseEmulatorName = "BITRISE_EMULATOR_NAME"
 )
 
 // ConfigsModel ...
@@ -29,37 +23,169 @@ type ConfigsModel struct {
 	Abi                          string
 	Tag                          string
 	Options                      string
+	HardwareProfilePreset        string
+	HardwareProfileStrict        string
 	CustomHardwareProfileContent string
+	WarmUpSnapshot               string
+	Boot                         string
+	BootTimeout                  string
+	EmulatorOptions              string
+	EmulatorPort                 string
+	AccelerationCheck            string
+	PrepareDevice                string
+	DeviceLocale                 string
+	DeviceTimezone               string
+	CaptureLogs                  string
+	LogcatBuffers                string
+	LogcatFilters                string
+	LogRotateSize                string
+	LogRotateCount               string
+	Mode                         string
+	ExportAVD                    string
+	AVDArchivePath               string
+	CacheIncludeAVD              string
+	TemplateCacheDir             string
+	EnvExporter                  string
+	DotenvPath                   string
 	AndroidHome                  string
 }
 
+// inputDefaults are the non-empty default values of the step inputs, as declared in step.yml.
+// The step falls back to them for the inputs not set in the environment (bitrise sets every input),
+// and the CLI commands start from them.
+var inputDefaults = map[string]string{
+	"platform":                "android-19",
+	"abi":                     "armeabi-v7a",
+	"tag":                     "default",
+	"hardware_profile_strict": "no",
+	"acceleration_check":      "warn",
+	"warm_up_snapshot":        "no",
+	"export_avd":              exportAVDNo,
+	"cache_include_avd":       "no",
+	"boot":                    "no",
+	"boot_timeout":            "600",
+	"prepare_device":          "no",
+	"capture_logs":            "no",
+	"logcat_buffers":          "main,system,crash",
+	"log_rotate_size_mb":      "50",
+	"log_rotate_count":        "5",
+	"emulator_port":           "auto",
+	"mode":                    modeCreate,
+	"env_exporter":            exporter.TypeAuto,
+}
+
+// envInput returns the value of the step input from the environment, or its default value if it is not set.
+func envInput(key string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return inputDefaults[key]
+}
+
 func createConfigsModelFromEnvs() ConfigsModel {
+	return configsFromInputs(envInput)
+}
+
+// configsFromInputs returns the configs with the step input values returned by input.
+func configsFromInputs(input func(key string) string) ConfigsModel {
 	return ConfigsModel{
-		Name:     os.Getenv("name"),
-		Platform: os.Getenv("platform"),
-		Abi:      os.Getenv("abi"),
-		Tag:      os.Getenv("tag"),
-		Options:  os.Getenv("options"),
-		CustomHardwareProfileContent: os.Getenv("custom_hardware_profile_content"),
+		Name:                         input("name"),
+		Platform:                     input("platform"),
+		Abi:                          input("abi"),
+		Tag:                          input("tag"),
+		Options:                      input("options"),
+		HardwareProfilePreset:        input("hardware_profile_preset"),
+		HardwareProfileStrict:        input("hardware_profile_strict"),
+		CustomHardwareProfileContent: input("custom_hardware_profile_content"),
+		WarmUpSnapshot:               input("warm_up_snapshot"),
+		Boot:                         input("boot"),
+		BootTimeout:                  input("boot_timeout"),
+		EmulatorOptions:              input("emulator_options"),
+		EmulatorPort:                 input("emulator_port"),
+		AccelerationCheck:            input("acceleration_check"),
+		PrepareDevice:                input("prepare_device"),
+		DeviceLocale:                 input("device_locale"),
+		DeviceTimezone:               input("device_timezone"),
+		CaptureLogs:                  input("capture_logs"),
+		LogcatBuffers:                input("logcat_buffers"),
+		LogcatFilters:                input("logcat_filters"),
+		LogRotateSize:                input("log_rotate_size_mb"),
+		LogRotateCount:               input("log_rotate_count"),
+		Mode:                         input("mode"),
+		ExportAVD:                    input("export_avd"),
+		AVDArchivePath:               input("avd_archive_path"),
+		CacheIncludeAVD:              input("cache_include_avd"),
+		TemplateCacheDir:             input("template_cache_dir"),
+		EnvExporter:                  input("env_exporter"),
+		DotenvPath:                   input("dotenv_path"),
 		AndroidHome:                  os.Getenv("ANDROID_HOME"),
 	}
 }
 
 func (configs ConfigsModel) print() {
 	log.Infof("Configs:")
+	log.Printf("- Mode: %s", configs.Mode)
+	log.Printf("- AVDArchivePath: %s", configs.AVDArchivePath)
 	log.Printf("- Name: %s", configs.Name)
 	log.Printf("- Platform: %s", configs.Platform)
 	log.Printf("- Abi: %s", configs.Abi)
 	log.Printf("- Tag: %s", configs.Tag)
 	log.Printf("- Options: %s", configs.Options)
+	log.Printf("- WarmUpSnapshot: %s", configs.WarmUpSnapshot)
+	log.Printf("- ExportAVD: %s", configs.ExportAVD)
+	log.Printf("- Boot: %s", configs.Boot)
+	log.Printf("- BootTimeout: %s", configs.BootTimeout)
+	log.Printf("- EmulatorOptions: %s", configs.EmulatorOptions)
+	log.Printf("- EmulatorPort: %s", configs.EmulatorPort)
+	log.Printf("- AccelerationCheck: %s", configs.AccelerationCheck)
+	log.Printf("- PrepareDevice: %s", configs.PrepareDevice)
+	log.Printf("- DeviceLocale: %s", configs.DeviceLocale)
+	log.Printf("- DeviceTimezone: %s", configs.DeviceTimezone)
+	log.Printf("- CaptureLogs: %s", configs.CaptureLogs)
+	log.Printf("- LogcatBuffers: %s", configs.LogcatBuffers)
+	log.Printf("- LogcatFilters: %s", configs.LogcatFilters)
+	log.Printf("- LogRotateSize: %s", configs.LogRotateSize)
+	log.Printf("- LogRotateCount: %s", configs.LogRotateCount)
+	log.Printf("- CacheIncludeAVD: %s", configs.CacheIncludeAVD)
+	log.Printf("- TemplateCacheDir: %s", configs.TemplateCacheDir)
+	log.Printf("- EnvExporter: %s", configs.EnvExporter)
+	log.Printf("- DotenvPath: %s", configs.DotenvPath)
 	log.Printf("- AndroidHome: %s", configs.AndroidHome)
+	log.Printf("- HardwareProfilePreset: %s", configs.HardwareProfilePreset)
+	log.Printf("- HardwareProfileStrict: %s", configs.HardwareProfileStrict)
 	log.Printf("- CustomHardwareProfileContent:")
 	log.Printf(configs.CustomHardwareProfileContent)
 }
 
 func (configs ConfigsModel) validate() error {
-	if configs.Name == "" {
-		return errors.New("no Name parameter specified")
+	if !isValueValid(configs.Mode, modes) {
+		return fmt.Errorf("invalid Mode parameter specified (%s), valid options: %s", configs.Mode, modes)
+	}
+
+	// the imported AVD is named by its archive
+	if configs.Mode != modeImportAVD {
+		if configs.Name == "" {
+			return errors.New("no Name parameter specified")
+		}
+		if err := avd.ValidateName(configs.Name); err != nil {
+			return fmt.Errorf("invalid Name parameter specified, error: %s", err)
+		}
+	}
+
+	if err := configs.validateExporter(); err != nil {
+		return err
+	}
+
+	switch configs.Mode {
+	case modeCollectLogs:
+		return nil
+	case modeImportAVD:
+		if configs.AVDArchivePath == "" {
+			return errors.New("no AVDArchivePath parameter specified")
+		} else if configs.AndroidHome == "" {
+			return errors.New("no ANDROID_HOME env set")
+		}
+		return nil
 	}
 
 	if configs.Platform == "" {
@@ -80,205 +206,191 @@ func (configs ConfigsModel) validate() error {
 		return fmt.Errorf("invalid Tag parameter specified (%s), valid options: %s", configs.Tag, validTags)
 	}
 
-	if configs.AndroidHome == "" {
-		return errors.New("no ANDROID_HOME env set")
+	if configs.HardwareProfilePreset != "" && !isValueValid(configs.HardwareProfilePreset, hardwareprofile.Presets) {
+		return fmt.Errorf("invalid HardwareProfilePreset parameter specified (%s), valid options: %s", configs.HardwareProfilePreset, hardwareprofile.Presets)
 	}
 
-	return nil
-}
-
-func isValueValid(value string, validValues []string) bool {
-	for _, v := range validValues {
-		if v == value {
-			return true
-		}
+	if !isValueValid(configs.HardwareProfileStrict, []string{"yes", "no"}) {
+		return fmt.Errorf("invalid HardwareProfileStrict parameter specified (%s), valid options: [yes no]", configs.HardwareProfileStrict)
 	}
-	return false
-}
-
-func fail(format string, v ...interface{}) {
-	log.Errorf(format, v...)
-	os.Exit(1)
-}
 
-func main() {
-	configs := createConfigsModelFromEnvs()
-
-	fmt.Println()
-	configs.print()
-
-	if err := configs.validate(); err != nil {
-		fail("Issue with input: %s", err)
+	if !isValueValid(configs.Boot, []string{"yes", "no"}) {
+		return fmt.Errorf("invalid Boot parameter specified (%s), valid options: [yes no]", configs.Boot)
 	}
 
-	//
-	// Check is platform installed
-	fmt.Println()
-	log.Infof("Check if platform installed")
-
-	androidSdk, err := sdk.New(configs.AndroidHome)
-	if err != nil {
-		fail("Failed to create sdk, error: %s", err)
+	if !isValueValid(configs.ExportAVD, []string{exportAVDNo, exportAVDYes, exportAVDWithSnapshots}) {
+		return fmt.Errorf("invalid ExportAVD parameter specified (%s), valid options: [%s %s %s]", configs.ExportAVD, exportAVDNo, exportAVDYes, exportAVDWithSnapshots)
 	}
 
-	manager, err := sdkmanager.New(androidSdk)
-	if err != nil {
-		fail("Failed to create sdk manager, error: %s", err)
+	if !isValueValid(configs.WarmUpSnapshot, []string{"yes", "no"}) {
+		return fmt.Errorf("invalid WarmUpSnapshot parameter specified (%s), valid options: [yes no]", configs.WarmUpSnapshot)
 	}
 
-	platformComponent := sdkcomponent.Platform{
-		Version: configs.Platform,
+	if configs.Boot == "yes" || configs.WarmUpSnapshot == "yes" {
+		if timeout, err := strconv.Atoi(configs.BootTimeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid BootTimeout parameter specified (%s), should be a positive number of seconds", configs.BootTimeout)
+		}
 	}
 
-	platformInstalled, err := manager.IsInstalled(platformComponent)
-	if err != nil {
-		fail("Failed to check if platform (%s) installed, error: %s", platformComponent.Version)
+	if !isValueValid(configs.PrepareDevice, []string{"yes", "no"}) {
+		return fmt.Errorf("invalid PrepareDevice parameter specified (%s), valid options: [yes no]", configs.PrepareDevice)
+	} else if configs.PrepareDevice == "yes" && configs.Boot != "yes" {
+		return errors.New("PrepareDevice requires Boot to be yes")
 	}
 
-	log.Donef("installed: %v", platformInstalled)
-	// ---
-
-	//
-	// Install platform
-	if !platformInstalled {
-		fmt.Println()
-		log.Infof("Installing: %s", configs.Platform)
-
-		installCmd := manager.InstallCommand(platformComponent)
-		installCmd.SetStdin(strings.NewReader("y"))
-		installCmd.SetStdout(os.Stdout)
-		installCmd.SetStderr(os.Stderr)
+	if configs.DeviceLocale != "" && !localePattern.MatchString(configs.DeviceLocale) {
+		return fmt.Errorf("invalid DeviceLocale parameter specified (%s), should be a language tag like en-US", configs.DeviceLocale)
+	}
 
-		fmt.Println()
-		log.Donef("$ %s", installCmd.PrintableCommandArgs())
-		fmt.Println()
+	if configs.DeviceTimezone != "" && !timezonePattern.MatchString(configs.DeviceTimezone) {
+		return fmt.Errorf("invalid DeviceTimezone parameter specified (%s), should be a timezone ID like Europe/Budapest", configs.DeviceTimezone)
+	}
 
-		if err := installCmd.Run(); err != nil {
-			fail("Failed to install platform, error: %s", err)
+	if configs.EmulatorPort != "auto" {
+		if port, err := strconv.Atoi(configs.EmulatorPort); err != nil || port < emulator.FirstConsolePort || port > emulator.LastConsolePort || port%2 != 0 {
+			return fmt.Errorf("invalid EmulatorPort parameter specified (%s), should be auto or an even number between %d and %d", configs.EmulatorPort, emulator.FirstConsolePort, emulator.LastConsolePort)
 		}
+	}
 
-		if installed, err := manager.IsInstalled(platformComponent); err != nil {
-			fail("Failed to check if platform (%s) installed, error: %s", platformComponent.Version)
-		} else if !installed {
-			fail("Failed to install platform")
-		}
+	if !isValueValid(configs.AccelerationCheck, []string{"warn", "fail", "off"}) {
+		return fmt.Errorf("invalid AccelerationCheck parameter specified (%s), valid options: [warn fail off]", configs.AccelerationCheck)
+	}
 
-		log.Donef("Installed")
+	if !isValueValid(configs.CacheIncludeAVD, []string{"yes", "no"}) {
+		return fmt.Errorf("invalid CacheIncludeAVD parameter specified (%s), valid options: [yes no]", configs.CacheIncludeAVD)
 	}
-	// ---
 
-	//
-	// Check if system image installed
-	fmt.Println()
-	log.Infof("Check if system image installed")
+	if err := configs.validateLogCapture(); err != nil {
+		return err
+	}
 
-	systemImageComponent := sdkcomponent.SystemImage{
-		Platform: configs.Platform,
-		Tag:      configs.Tag,
-		ABI:      configs.Abi,
+	if configs.AndroidHome == "" {
+		return errors.New("no ANDROID_HOME env set")
 	}
 
-	log.Printf("Checking path: %s", systemImageComponent.InstallPathInAndroidHome())
+	return nil
+}
 
-	systemImageInstalled, err := manager.IsInstalled(systemImageComponent)
-	if err != nil {
-		fail("Failed to check if system image (platform: %s abi: %s tag: %s) installed, error: %s", systemImageComponent.Platform, systemImageComponent.ABI, systemImageComponent.Tag, err)
+func (configs ConfigsModel) validateExporter() error {
+	if !isValueValid(configs.EnvExporter, exporter.Types) {
+		return fmt.Errorf("invalid EnvExporter parameter specified (%s), valid options: %s", configs.EnvExporter, exporter.Types)
+	} else if configs.EnvExporter == exporter.TypeDotenv && configs.DotenvPath == "" {
+		return errors.New("no DotenvPath parameter specified for the dotenv EnvExporter")
 	}
+	return nil
+}
 
-	log.Donef("installed: %v", systemImageInstalled)
-	// ---
-
-	//
-	// Install system image
-	if !systemImageInstalled {
-		fmt.Println()
-		log.Infof("Installing system image (platform: %s abi: %s tag: %s)", systemImageComponent.Platform, systemImageComponent.ABI, systemImageComponent.Tag)
+func isValueValid(value string, validValues []string) bool {
+	for _, v := range validValues {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
 
-		installCmd := manager.InstallCommand(systemImageComponent)
-		installCmd.SetStdin(strings.NewReader("y"))
-		installCmd.SetStdout(os.Stdout)
-		installCmd.SetStderr(os.Stderr)
+var failOnce sync.Once
 
-		fmt.Println()
-		log.Donef("$ %s", installCmd.PrintableCommandArgs())
-		fmt.Println()
+// fail logs the error, writes the run report and the error file, and exits with the exit code of the error kind.
+// It is safe to call from the signal handler, only the first call reports the failure.
+func fail(err error) {
+	failOnce.Do(func() {
+		failWith(asStepError(err))
+	})
+}
 
-		if err := installCmd.Run(); err != nil {
-			fail("Failed to install platform, error: %s", err)
-		}
+func failWith(stepErr *StepError) {
+	log.Errorf("%s", stepErr)
 
-		if installed, err := manager.IsInstalled(systemImageComponent); err != nil {
-			fail("Failed to check if system image (platform: %s abi: %s tag: %s) installed, error: %s", systemImageComponent.Platform, systemImageComponent.ABI, systemImageComponent.Tag, err)
-		} else if !installed {
-			fail("Failed to install system image (platform: %s abi: %s tag: %s)", systemImageComponent.Platform, systemImageComponent.ABI, systemImageComponent.Tag)
-		}
+	phase := runReport.runningPhaseName()
+	runReport.finish(outcomeFailed, stepErr.Error())
+	writeRunReport()
 
-		log.Donef("Installed")
+	pth := deployPath(errorFileName)
+	if err := writeErrorFile(pth, stepErr, phase); err != nil {
+		log.Warnf("Failed to write error file, error: %s", err)
+	} else {
+		log.Printf("Error file: %s", pth)
 	}
-	// ---
 
-	//
-	// Create AVD image
-	fmt.Println()
-	log.Infof("Creating AVD image")
+	os.Exit(stepErr.ExitCode())
+}
+
+func writeRunReport() {
+	pth := deployPath(runReportFileName)
+	if err := runReport.write(pth); err != nil {
+		log.Warnf("Failed to write run report, error: %s", err)
+		return
+	}
+	log.Printf("Run report: %s", pth)
+}
 
-	options := []string{}
-	if configs.Options != "" {
-		opts, err := shellquote.Split(configs.Options)
-		if err != nil {
-			fail("Failed to split custom options: %v", configs.Options)
+func main() {
+	if len(os.Args) == 3 && os.Args[1] == logcapture.CaptureArg {
+		if err := logcapture.Run(os.Args[2]); err != nil {
+			log.Errorf("Log capture failed, error: %s", err)
+			os.Exit(1)
 		}
-		options = opts
+		return
 	}
 
-	avdManager, err := avdmanager.New(androidSdk)
-	if err != nil {
-		fail("Failed to create avd manager, error: %s", err)
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
 	}
 
-	cmd := avdManager.CreateAVDCommand(configs.Name, systemImageComponent, options...)
-	cmd.SetStdin(strings.NewReader("n"))
-	cmd.SetStdout(os.Stdout)
-	cmd.SetStderr(os.Stderr)
+	runStep(createConfigsModelFromEnvs())
+}
 
-	fmt.Println()
-	log.Donef("$ %s", cmd.PrintableCommandArgs())
-	fmt.Println()
+// runStep runs the step with the given configs, it exits with the code of the error kind on failure.
+func runStep(configs ConfigsModel) {
+	cancellation.watch()
 
-	if err := cmd.Run(); err != nil {
-		fail("Failed to create image, error: %s", err)
-	}
-	// ---
+	runReport.startPhase("validate")
 
-	//
-	// Write custom hardware profile
-	if configs.CustomHardwareProfileContent != "" {
-		fmt.Println()
-		log.Infof("Applying custom hardware profile")
+	log.Printf("")
+	configs.print()
 
-		homeDir := pathutil.UserHomeDir()
-		avdImageDir := filepath.Join(homeDir, ".android/avd", configs.Name+".avd")
+	if err := configs.validate(); err != nil {
+		fail(newStepError(errInvalidInput, nil, "Issue with input: %s", err))
+	}
 
-		if exist, err := pathutil.IsDirExists(avdImageDir); err != nil {
-			fail("Failed to check if avd image dir (%s) exists, error: %s", avdImageDir, err)
-		} else if !exist {
-			fail("The avd image (%s) created but not found at: %s", configs.Name, avdImageDir)
-		}
+	envExporter, err := exporter.New(configs.EnvExporter, configs.DotenvPath)
+	if err != nil {
+		fail(newStepError(errExportFailed, err, "Failed to create env exporter"))
+	}
 
-		configPth := filepath.Join(avdImageDir, "config.ini")
-		if err := fileutil.WriteStringToFile(configPth, configs.CustomHardwareProfileContent); err != nil {
-			fail("Failed to write custom hardware profile, error: %s", err)
+	switch configs.Mode {
+	case modeCollectLogs:
+		runReportFileName = collectLogsReportFileName
+		err = collectLogs(configs.Name, envExporter)
+	case modeImportAVD:
+		runReportFileName = importReportFileName
+		err = importAVD(configs.AVDArchivePath, configs.AndroidHome, envExporter)
+	default:
+		err = runCreate(configs, envExporter)
+	}
+	if err != nil {
+		if cancellation.canceled() {
+			err = cancellation.canceledError()
 		}
+		fail(err)
+	}
 
-		log.Donef("config.ini path: %s", configPth)
-		fmt.Println()
+	runReport.finish(outcomeSuccess, "")
+	writeRunReport()
+}
+
+// runCreate runs the create mode pipeline with the Android SDK tools.
+func runCreate(configs ConfigsModel, envExporter exporter.Exporter) error {
+	androidSdk, err := sdk.New(configs.AndroidHome)
+	if err != nil {
+		return newStepError(errSDKToolMissing, err, "failed to create sdk")
 	}
-	// ---
 
-	if err := tools.ExportEnvironmentWithEnvman(bitriseEmulatorName, configs.Name); err != nil {
-		fail("Failed to export %s, error: %s", bitriseEmulatorName, err)
+	installer, creator, err := newSDKTools(androidSdk)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println()
-	log.Donef("Emulator name is exported in environment variable: %s (value: %s)", bitriseEmulatorName, configs.Name)
+	return newPipeline(configs, androidSdk.GetAndroidHome(), installer, creator, fileProfileWriter{}, envExporter).run()
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// stepInputPattern matches the input declarations of step.yml, like: - platform: android-19
+var stepInputPattern = regexp.MustCompile(`^  - ([a-z_]+):\s*(.*)$`)
+
+// stepInputDefaults returns the non-empty default values of the inputs declared in step.yml.
+func stepInputDefaults(t *testing.T) map[string]string {
+	b, err := ioutil.ReadFile("step.yml")
+	if err != nil {
+		t.Fatalf("failed to read step.yml: %s", err)
+	}
+
+	defaults := map[string]string{}
+	inInputs := false
+	for _, line := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(line, " ") && line != "" {
+			inInputs = line == "inputs:"
+			continue
+		}
+		match := stepInputPattern.FindStringSubmatch(line)
+		if !inInputs || match == nil {
+			continue
+		}
+
+		value := match[2]
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				t.Fatalf("invalid default value of input %s: %s", match[1], match[2])
+			}
+		}
+		if value != "" {
+			defaults[match[1]] = value
+		}
+	}
+	return defaults
+}
+
+func TestInputDefaults(t *testing.T) {
+	if want := stepInputDefaults(t); !reflect.DeepEqual(inputDefaults, want) {
+		t.Errorf("inputDefaults = %v, want the step.yml defaults %v", inputDefaults, want)
+	}
+}
+
+func unsetenv(key string) func() {
+	old, ok := os.LookupEnv(key)
+	_ = os.Unsetenv(key)
+	return func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		}
+	}
+}
+
+func TestCreateConfigsModelFromEnvs(t *testing.T) {
+	defer setenv("platform", "android-30")()
+	defer setenv("hardware_profile_strict", "")()
+	defer unsetenv("abi")()
+
+	configs := createConfigsModelFromEnvs()
+	if configs.Platform != "android-30" {
+		t.Errorf("Platform = %s, want the environment value android-30", configs.Platform)
+	}
+	if configs.HardwareProfileStrict != "" {
+		t.Errorf("HardwareProfileStrict = %s, want the empty environment value", configs.HardwareProfileStrict)
+	}
+	if configs.Abi != "armeabi-v7a" {
+		t.Errorf("Abi = %s, want the default value armeabi-v7a", configs.Abi)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		mode    string
+		name    string
+		wantErr bool
+	}{
+		{mode: modeCreate, name: "test"},
+		{mode: modeCreate, name: "", wantErr: true},
+		{mode: modeCreate, name: "../test", wantErr: true},
+		{mode: modeCollectLogs, name: "", wantErr: true},
+		{mode: modeImportAVD, name: ""},
+	}
+
+	for _, tt := range tests {
+		configs := defaultConfigs()
+		configs.Mode = tt.mode
+		configs.Name = tt.name
+		configs.AndroidHome = "/opt/android-sdk"
+		configs.AVDArchivePath = "/tmp/test.avd.tar.zst"
+
+		if err := configs.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate() of %s mode with name %q error = %v, want error: %v", tt.mode, tt.name, err, tt.wantErr)
+		}
+	}
+}
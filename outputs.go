@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+const (
+	bitriseEmulatorName                = "BITRISE_EMULATOR_NAME"
+	bitriseEmulatorAVDHome             = "BITRISE_EMULATOR_AVD_HOME"
+	bitriseEmulatorAVDPath             = "BITRISE_EMULATOR_AVD_PATH"
+	bitriseEmulatorConfigPath          = "BITRISE_EMULATOR_CONFIG_PATH"
+	bitriseEmulatorSystemImagePath     = "BITRISE_EMULATOR_SYSTEM_IMAGE_PATH"
+	bitriseEmulatorSystemImageRevision = "BITRISE_EMULATOR_SYSTEM_IMAGE_REVISION"
+	bitriseEmulatorAPILevel            = "BITRISE_EMULATOR_API_LEVEL"
+	bitriseEmulatorABI                 = "BITRISE_EMULATOR_ABI"
+	bitriseEmulatorTag                 = "BITRISE_EMULATOR_TAG"
+	bitriseEmulatorManifestPath        = "BITRISE_EMULATOR_MANIFEST_PATH"
+	bitriseEmulatorSerial              = "BITRISE_EMULATOR_SERIAL"
+	bitriseEmulatorPort                = "BITRISE_EMULATOR_PORT"
+	bitriseEmulatorLogsDir             = "BITRISE_EMULATOR_LOGS_DIR"
+	bitriseEmulatorLogsArchivePath     = "BITRISE_EMULATOR_LOGS_ARCHIVE_PATH"
+	bitriseEmulatorAVDArchivePath      = "BITRISE_EMULATOR_AVD_ARCHIVE_PATH"
+	bitriseEmulatorCachePaths          = "BITRISE_EMULATOR_CACHE_PATHS"
+	bitriseEmulatorCacheKey            = "BITRISE_EMULATOR_CACHE_KEY"
+)
+
+// SystemImageModel describes the installed system image of the AVD.
+type SystemImageModel struct {
+	Path     string `json:"path"`
+	Package  string `json:"package"`
+	Revision string `json:"revision"`
+	Platform string `json:"platform"`
+	APILevel int    `json:"api_level"`
+	ABI      string `json:"abi"`
+	Tag      string `json:"tag"`
+}
+
+// AVDManifestModel describes the created AVD.
+type AVDManifestModel struct {
+	Name        string           `json:"name"`
+	AVDHome     string           `json:"avd_home"`
+	AVDPath     string           `json:"avd_path"`
+	IniPath     string           `json:"ini_path"`
+	ConfigPath  string           `json:"config_path"`
+	SystemImage SystemImageModel `json:"system_image"`
+	Port        int              `json:"port,omitempty"`
+	Serial      string           `json:"serial,omitempty"`
+	LogsDir     string           `json:"logs_dir,omitempty"`
+	ArchivePath string           `json:"archive_path,omitempty"`
+	Cache       CacheModel       `json:"cache"`
+}
+
+func newAVDManifest(name, androidHome string, systemImage sdkcomponent.SystemImage) (AVDManifestModel, error) {
+	systemImagePth := filepath.Join(androidHome, systemImage.InstallPathInAndroidHome())
+
+	properties, err := avd.ReadProperties(filepath.Join(systemImagePth, "source.properties"))
+	if err != nil {
+		return AVDManifestModel{}, fmt.Errorf("failed to read system image source.properties, error: %s", err)
+	}
+
+	apiLevel, err := apiLevel(systemImage.Platform, properties)
+	if err != nil {
+		return AVDManifestModel{}, err
+	}
+
+	tag := systemImage.Tag
+	if tag == "" {
+		tag = "default"
+	}
+
+	return AVDManifestModel{
+		Name:       name,
+		AVDHome:    avd.Home(),
+		AVDPath:    avd.Dir(name),
+		IniPath:    avd.IniPath(name),
+		ConfigPath: avd.ConfigPath(name),
+		SystemImage: SystemImageModel{
+			Path:     systemImagePth,
+			Package:  systemImage.GetSDKStylePath(),
+			Revision: properties["Pkg.Revision"],
+			Platform: systemImage.Platform,
+			APILevel: apiLevel,
+			ABI:      systemImage.ABI,
+			Tag:      tag,
+		},
+	}, nil
+}
+
+// apiLevel returns the API level of platforms like android-28,
+// preview platforms (like android-Q) are resolved from the system image's source.properties.
+func apiLevel(platform string, systemImageProperties map[string]string) (int, error) {
+	if level, err := strconv.Atoi(strings.TrimPrefix(platform, "android-")); err == nil {
+		return level, nil
+	}
+
+	level, err := strconv.Atoi(systemImageProperties["AndroidVersion.ApiLevel"])
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine the API level of platform (%s)", platform)
+	}
+	return level, nil
+}
+
+// manifestPath returns the path of the JSON manifest in the deploy dir.
+func manifestPath(name string) string {
+	return deployPath(name + "-avd-manifest.json")
+}
+
+func writeAVDManifest(pth string, manifest AVDManifestModel) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, b)
+}
+
+// outputs returns the step outputs in the order they are exported,
+// the port and the serial are only exported if a port is reserved,
+// the logs dir and the AVD archive path are only exported if the logs are captured and the AVD is exported.
+func (manifest AVDManifestModel) outputs(manifestPth string) [][2]string {
+	outputs := [][2]string{
+		{bitriseEmulatorName, manifest.Name},
+		{bitriseEmulatorAVDHome, manifest.AVDHome},
+		{bitriseEmulatorAVDPath, manifest.AVDPath},
+		{bitriseEmulatorConfigPath, manifest.ConfigPath},
+		{bitriseEmulatorSystemImagePath, manifest.SystemImage.Path},
+		{bitriseEmulatorSystemImageRevision, manifest.SystemImage.Revision},
+		{bitriseEmulatorAPILevel, strconv.Itoa(manifest.SystemImage.APILevel)},
+		{bitriseEmulatorABI, manifest.SystemImage.ABI},
+		{bitriseEmulatorTag, manifest.SystemImage.Tag},
+		{bitriseEmulatorManifestPath, manifestPth},
+	}
+	if manifest.Port != 0 {
+		outputs = append(outputs, [2]string{bitriseEmulatorPort, strconv.Itoa(manifest.Port)}, [2]string{bitriseEmulatorSerial, manifest.Serial})
+	}
+	outputs = append(outputs,
+		[2]string{bitriseEmulatorCachePaths, strings.Join(manifest.Cache.Paths, "\n")},
+		[2]string{bitriseEmulatorCacheKey, manifest.Cache.Key},
+	)
+	if manifest.LogsDir != "" {
+		outputs = append(outputs, [2]string{bitriseEmulatorLogsDir, manifest.LogsDir})
+	}
+	if manifest.ArchivePath != "" {
+		outputs = append(outputs, [2]string{bitriseEmulatorAVDArchivePath, manifest.ArchivePath})
+	}
+	return outputs
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+func TestExportOutputs(t *testing.T) {
+	androidHome, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	platform := sdkcomponent.Platform{Version: "android-19"}.GetSDKStylePath()
+	systemImage := sdkcomponent.SystemImage{Platform: "android-19", Tag: "default", ABI: "armeabi-v7a"}
+	installer := &fakeInstaller{installed: map[string]bool{platform: true, systemImage.GetSDKStylePath(): true}}
+	envExporter := &fakeExporter{exported: map[string]string{}}
+
+	configs := testConfigs(androidHome)
+	if err := newPipeline(configs, androidHome, installer, &fakeCreator{}, &fakeProfileWriter{}, envExporter).run(); err != nil {
+		t.Fatalf("run() error: %s", err)
+	}
+
+	systemImagePth := filepath.Join(androidHome, "system-images", "android-19", "default", "armeabi-v7a")
+	manifestPth := manifestPath(configs.Name)
+	want := map[string]string{
+		bitriseEmulatorName:                configs.Name,
+		bitriseEmulatorAVDHome:             avd.Home(),
+		bitriseEmulatorAVDPath:             avd.Dir(configs.Name),
+		bitriseEmulatorConfigPath:          avd.ConfigPath(configs.Name),
+		bitriseEmulatorSystemImagePath:     systemImagePth,
+		bitriseEmulatorSystemImageRevision: "5",
+		bitriseEmulatorAPILevel:            "19",
+		bitriseEmulatorABI:                 "armeabi-v7a",
+		bitriseEmulatorTag:                 "default",
+		bitriseEmulatorManifestPath:        manifestPth,
+	}
+	for key, value := range want {
+		if got := envExporter.exported[key]; got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	for _, key := range []string{bitriseEmulatorLogsDir, bitriseEmulatorAVDArchivePath} {
+		if _, ok := envExporter.exported[key]; ok {
+			t.Errorf("%s exported without the logs captured and the AVD exported", key)
+		}
+	}
+
+	cachePaths := envExporter.exported[bitriseEmulatorCachePaths]
+	if !strings.Contains(cachePaths, systemImagePth) || !strings.Contains(cachePaths, filepath.Join(androidHome, "platforms", "android-19")) {
+		t.Errorf("%s = %q, want the platform and the system image dirs", bitriseEmulatorCachePaths, cachePaths)
+	}
+
+	b, err := ioutil.ReadFile(manifestPth)
+	if err != nil {
+		t.Fatalf("failed to read the manifest: %s", err)
+	}
+	var manifest AVDManifestModel
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatalf("failed to parse the manifest: %s", err)
+	}
+	if manifest.SystemImage.APILevel != 19 {
+		t.Errorf("manifest API level = %d, want 19", manifest.SystemImage.APILevel)
+	}
+	if manifest.SystemImage.Path != systemImagePth || manifest.AVDPath != avd.Dir(configs.Name) {
+		t.Errorf("manifest paths = %s, %s, want %s, %s", manifest.SystemImage.Path, manifest.AVDPath, systemImagePth, avd.Dir(configs.Name))
+	}
+}
+
+func TestAPILevel(t *testing.T) {
+	tests := []struct {
+		platform   string
+		properties map[string]string
+		want       int
+		wantErr    bool
+	}{
+		{platform: "android-28", want: 28},
+		{platform: "android-Q", properties: map[string]string{"AndroidVersion.ApiLevel": "28"}, want: 28},
+		{platform: "android-Q", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := apiLevel(tt.platform, tt.properties)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("apiLevel(%s) error = %v, want error: %v", tt.platform, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("apiLevel(%s) = %d, want %d", tt.platform, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+	"github.com/bitrise-steplib/steps-create-android-emulator/exporter"
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-steplib/steps-create-android-emulator/logcapture"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+	"github.com/kballard/go-shellquote"
+)
+
+// stage is a phase of the create mode, recorded in the run report under its name.
+type stage struct {
+	name  string
+	title string
+	// skip reports if the stage does not need to run, nil means the stage always runs.
+	skip func() bool
+	run  func() error
+}
+
+// pipeline creates (and optionally boots) the AVD described by the configs,
+// it talks to the Android SDK tools and the env exporter through the interfaces it is created with.
+type pipeline struct {
+	configs     ConfigsModel
+	androidHome string
+
+	installer   componentInstaller
+	creator     avdCreator
+	profiles    profileWriter
+	envExporter exporter.Exporter
+
+	platform        sdkcomponent.Platform
+	systemImage     sdkcomponent.SystemImage
+	hardwareProfile hardwareprofile.Profile
+	presetProfile   *hardwareprofile.Profile
+	options         []string
+	emulatorOptions []string
+	bootTimeout     time.Duration
+
+	platformInstalled    bool
+	systemImageInstalled bool
+	templateCache        avd.TemplateCache
+	templateKey          string
+	spec                 avdSpec
+	materialized         bool
+	warmedUp             bool
+	port                 int
+	serial               string
+	archivePth           string
+}
+
+func newPipeline(configs ConfigsModel, androidHome string, installer componentInstaller, creator avdCreator, profiles profileWriter, envExporter exporter.Exporter) *pipeline {
+	return &pipeline{
+		configs:     configs,
+		androidHome: androidHome,
+		installer:   installer,
+		creator:     creator,
+		profiles:    profiles,
+		envExporter: envExporter,
+		platform:    sdkcomponent.Platform{Version: configs.Platform},
+		systemImage: sdkcomponent.SystemImage{
+			Platform: configs.Platform,
+			Tag:      configs.Tag,
+			ABI:      configs.Abi,
+		},
+	}
+}
+
+// setup resolves the hardware profile and splits the options of the validated configs.
+func (p *pipeline) setup() error {
+	profile, err := customHardwareProfile(p.configs, p.systemImage)
+	if err != nil {
+		return newStepError(errInvalidInput, nil, "invalid custom hardware profile:\n%s", err)
+	}
+
+	warnings, err := resolveHardwareProfileConflicts(&profile, p.systemImage, p.configs.HardwareProfileStrict == "yes")
+	if err != nil {
+		return newStepError(errInvalidInput, nil, "custom hardware profile contradicts the inputs:\n%s", err)
+	}
+	for _, warning := range warnings {
+		log.Warnf("%s", warning)
+	}
+	p.hardwareProfile = profile
+
+	if p.configs.HardwareProfilePreset != "" {
+		preset, err := hardwareprofile.NewPreset(p.configs.HardwareProfilePreset, p.systemImage)
+		if err != nil {
+			return newStepError(errInvalidInput, nil, "issue with input: %s", err)
+		}
+		p.presetProfile = &preset
+	}
+
+	p.options = []string{}
+	if p.configs.Options != "" {
+		if p.options, err = shellquote.Split(p.configs.Options); err != nil {
+			return newStepError(errInvalidInput, nil, "failed to split custom options: %v", p.configs.Options)
+		}
+	}
+
+	p.emulatorOptions = append([]string{}, emulator.HeadlessOptions...)
+	if p.configs.EmulatorOptions != "" {
+		opts, err := shellquote.Split(p.configs.EmulatorOptions)
+		if err != nil {
+			return newStepError(errInvalidInput, nil, "failed to split emulator options: %v", p.configs.EmulatorOptions)
+		}
+		p.emulatorOptions = append(p.emulatorOptions, opts...)
+	}
+
+	bootTimeout, _ := strconv.Atoi(p.configs.BootTimeout)
+	p.bootTimeout = time.Duration(bootTimeout) * time.Second
+
+	return nil
+}
+
+func (p *pipeline) stages() []stage {
+	return []stage{
+		{
+			name:  "check_acceleration",
+			title: "Checking hardware acceleration",
+			skip: func() bool {
+				return p.configs.AccelerationCheck == "off" || !needsAcceleration(p.configs.Abi)
+			},
+			run: p.checkAcceleration,
+		},
+		{
+			name:  "check_platform",
+			title: "Check if platform installed",
+			run:   p.checkPlatform,
+		},
+		{
+			name:  "install_platform",
+			title: fmt.Sprintf("Installing: %s", p.configs.Platform),
+			skip:  func() bool { return p.platformInstalled },
+			run: func() error {
+				return p.install(p.platform, fmt.Sprintf("platform (%s)", p.platform.Version))
+			},
+		},
+		{
+			name:  "check_system_image",
+			title: "Check if system image installed",
+			run:   p.checkSystemImage,
+		},
+		{
+			name:  "install_system_image",
+			title: fmt.Sprintf("Installing system image (%s)", p.systemImageDescription()),
+			skip:  func() bool { return p.systemImageInstalled },
+			run: func() error {
+				return p.install(p.systemImage, fmt.Sprintf("system image (%s)", p.systemImageDescription()))
+			},
+		},
+		{
+			name:  "materialize_avd",
+			title: "Looking up AVD template",
+			skip:  func() bool { return p.configs.TemplateCacheDir == "" },
+			run:   p.materializeAVD,
+		},
+		{
+			name:  "create_avd",
+			title: "Creating AVD image",
+			skip:  func() bool { return p.materialized },
+			run:   p.createAVD,
+		},
+		{
+			name:  "apply_hardware_profile",
+			title: "Applying custom hardware profile",
+			skip: func() bool {
+				return p.materialized || (p.presetProfile == nil && p.configs.CustomHardwareProfileContent == "")
+			},
+			run: p.applyHardwareProfile,
+		},
+		{
+			name:  "allocate_port",
+			title: "Reserving emulator port",
+			run:   p.allocatePort,
+		},
+		{
+			name:  "warm_up_snapshot",
+			title: "Warming up quickboot snapshot",
+			skip:  p.skipWarmUp,
+			run: func() error {
+				cancellation.partial(avd.SnapshotDir(p.configs.Name, quickbootSnapshot))
+				if err := warmUpSnapshot(p.androidHome, p.configs.Name, p.port, p.emulatorOptions, p.bootTimeout); err != nil {
+					return fmt.Errorf("failed to warm up snapshot, error: %s", err)
+				}
+				p.warmedUp = true
+				return nil
+			},
+		},
+		{
+			name:  "store_avd_template",
+			title: "Storing AVD template",
+			// a template materialized without the quickboot snapshot is stored again once it is warmed up
+			skip: func() bool { return p.configs.TemplateCacheDir == "" || (p.materialized && !p.warmedUp) },
+			run:  p.storeAVDTemplate,
+		},
+		{
+			name:  "export_avd",
+			title: "Exporting AVD archive",
+			skip:  func() bool { return p.configs.ExportAVD == exportAVDNo },
+			run: func() error {
+				p.archivePth = avdArchivePath(p.configs.Name)
+				cancellation.partial(p.archivePth)
+				if err := exportAVD(p.configs.Name, p.androidHome, p.archivePth, p.configs.ExportAVD == exportAVDWithSnapshots); err != nil {
+					return newStepError(errExportFailed, err, "failed to export AVD")
+				}
+				return nil
+			},
+		},
+		{
+			name:  "boot",
+			title: "Booting emulator",
+			skip:  func() bool { return p.configs.Boot != "yes" },
+			run:   p.boot,
+		},
+		{
+			name:  "prepare_device",
+			title: "Preparing device for UI testing",
+			skip:  func() bool { return p.configs.PrepareDevice != "yes" },
+			run:   p.prepareDevice,
+		},
+		{
+			name:  "export",
+			title: "Exporting outputs",
+			run:   p.exportOutputs,
+		},
+	}
+}
+
+// run sets up the pipeline and runs its stages in order, it stops at the first failing stage.
+func (p *pipeline) run() error {
+	if err := p.setup(); err != nil {
+		return err
+	}
+
+	for _, s := range p.stages() {
+		if cancellation.canceled() {
+			return cancellation.canceledError()
+		}
+
+		if s.skip != nil && s.skip() {
+			runReport.skipPhase(s.name)
+			continue
+		}
+
+		runReport.startPhase(s.name)
+		log.Printf("")
+		log.Infof(s.title)
+
+		if err := s.run(); err != nil {
+			return err
+		}
+		cancellation.complete()
+	}
+	return nil
+}
+
+func (p *pipeline) systemImageDescription() string {
+	return fmt.Sprintf("platform: %s abi: %s tag: %s", p.systemImage.Platform, p.systemImage.ABI, p.systemImage.Tag)
+}
+
+func (p *pipeline) checkAcceleration() error {
+	if !checkAcceleration(p.androidHome) {
+		msg := fmt.Sprintf("The %s emulator image can not be run with hardware acceleration on this host", p.configs.Abi)
+		if p.configs.AccelerationCheck == "fail" {
+			return fmt.Errorf("%s", msg)
+		}
+		log.Warnf("%s", msg)
+	}
+	return nil
+}
+
+func (p *pipeline) checkPlatform() error {
+	installed, err := p.installer.IsInstalled(p.platform)
+	if err != nil {
+		return fmt.Errorf("failed to check if platform (%s) installed, error: %s", p.platform.Version, err)
+	}
+
+	p.platformInstalled = installed
+	log.Donef("installed: %v", installed)
+	return nil
+}
+
+func (p *pipeline) checkSystemImage() error {
+	log.Printf("Checking path: %s", p.systemImage.InstallPathInAndroidHome())
+
+	installed, err := p.installer.IsInstalled(p.systemImage)
+	if err != nil {
+		return fmt.Errorf("failed to check if system image (%s) installed, error: %s", p.systemImageDescription(), err)
+	}
+
+	p.systemImageInstalled = installed
+	log.Donef("installed: %v", installed)
+	return nil
+}
+
+// install runs the install command of the component, and checks that it was installed.
+// A component dir created by the install is removed if the step is canceled.
+func (p *pipeline) install(component sdkcomponent.Model, description string) error {
+	componentDir := filepath.Join(p.androidHome, component.InstallPathInAndroidHome())
+	cancellation.partialIfNew(componentDir)
+
+	cmd := p.installer.InstallCommand(component)
+	runReport.setCommand(cmd.PrintableCommandArgs())
+
+	log.Printf("")
+	log.Donef("$ %s", cmd.PrintableCommandArgs())
+	log.Printf("")
+
+	if err := cmd.Run(); err != nil {
+		return newStepError(errorKind(err, errInstallFailed), err, "failed to install %s", description)
+	}
+
+	if installed, err := p.installer.IsInstalled(component); err != nil {
+		return fmt.Errorf("failed to check if %s installed, error: %s", description, err)
+	} else if !installed {
+		return newStepError(errInstallFailed, nil, "failed to install %s", description)
+	}
+
+	log.Donef("Installed")
+	return nil
+}
+
+func (p *pipeline) materializeAVD() error {
+	spec, err := newAVDSpec(p.configs.Name, p.androidHome, p.systemImage, p.options, p.configs.HardwareProfilePreset, p.presetProfile, p.hardwareProfile)
+	if err != nil {
+		return fmt.Errorf("failed to describe the AVD spec, error: %s", err)
+	}
+	p.spec = spec
+
+	if p.templateKey, err = spec.hash(); err != nil {
+		return fmt.Errorf("failed to hash the AVD spec, error: %s", err)
+	}
+	log.Printf("Spec hash: %s", p.templateKey)
+
+	p.templateCache = avd.NewTemplateCache(p.configs.TemplateCacheDir)
+	cancellation.partialIfNew(avd.Dir(p.configs.Name), avd.IniPath(p.configs.Name))
+	if p.materialized, err = materializeAVDTemplate(p.templateCache, p.templateKey, p.androidHome); err != nil {
+		return newStepError(errAVDCreateFailed, err, "failed to materialize AVD from template")
+	}
+	return nil
+}
+
+func (p *pipeline) createAVD() error {
+	cancellation.partialIfNew(avd.Dir(p.configs.Name), avd.IniPath(p.configs.Name))
+
+	cmd := p.creator.CreateCommand(p.configs.Name, p.systemImage, p.options)
+	runReport.setCommand(cmd.PrintableCommandArgs())
+
+	log.Printf("")
+	log.Donef("$ %s", cmd.PrintableCommandArgs())
+	log.Printf("")
+
+	if err := cmd.Run(); err != nil {
+		return newStepError(errAVDCreateFailed, err, "failed to create image")
+	}
+
+	avdImageDir := avd.Dir(p.configs.Name)
+	if exist, err := pathutil.IsDirExists(avdImageDir); err != nil {
+		return newStepError(errAVDCreateFailed, err, "failed to check if avd image dir (%s) exists", avdImageDir)
+	} else if !exist {
+		return newStepError(errAVDCreateFailed, nil, "the avd image (%s) created but not found at: %s", p.configs.Name, avdImageDir)
+	}
+	return nil
+}
+
+func (p *pipeline) applyHardwareProfile() error {
+	configPth := avd.ConfigPath(p.configs.Name)
+
+	profile := p.hardwareProfile
+	if p.presetProfile != nil {
+		log.Printf("Using preset: %s", p.configs.HardwareProfilePreset)
+
+		merged, err := mergePresetHardwareProfile(configPth, *p.presetProfile, p.hardwareProfile)
+		if err != nil {
+			return newStepError(errProfileWriteFailed, err, "failed to apply hardware profile preset")
+		}
+		profile = merged
+	}
+
+	if err := p.profiles.Write(configPth, profile); err != nil {
+		return newStepError(errProfileWriteFailed, err, "failed to write custom hardware profile")
+	}
+
+	log.Donef("config.ini path: %s", configPth)
+	log.Printf("")
+	return nil
+}
+
+func (p *pipeline) allocatePort() error {
+	portAllocator := emulator.NewPortAllocator(avd.Home())
+
+	var err error
+	if p.configs.EmulatorPort == "auto" {
+		p.port, err = portAllocator.Reserve(p.configs.Name)
+	} else {
+		p.port, _ = strconv.Atoi(p.configs.EmulatorPort)
+		err = portAllocator.ReservePort(p.configs.Name, p.port)
+	}
+	if err != nil {
+		// the port is only exported for later steps if the emulator is neither warmed up nor booted
+		if p.configs.Boot != "yes" && p.configs.WarmUpSnapshot != "yes" {
+			p.port = 0
+			log.Warnf("Failed to reserve emulator port, BITRISE_EMULATOR_PORT and BITRISE_EMULATOR_SERIAL are not exported, error: %s", err)
+			return nil
+		}
+		return fmt.Errorf("failed to reserve emulator port, error: %s", err)
+	}
+
+	p.serial = emulator.Serial(p.port)
+	log.Donef("Reserved ports %d (console) and %d (adb), serial: %s", p.port, p.port+1, p.serial)
+	return nil
+}
+
+// skipWarmUp skips the warm-up if it is not requested, or if the AVD was materialized from a template with the quickboot snapshot.
+func (p *pipeline) skipWarmUp() bool {
+	if p.configs.WarmUpSnapshot != "yes" {
+		return true
+	}
+	if !p.materialized {
+		return false
+	}
+
+	exist, err := pathutil.IsPathExists(filepath.Join(avd.SnapshotDir(p.configs.Name, quickbootSnapshot), "snapshot.pb"))
+	if err != nil {
+		log.Warnf("Failed to check if the quickboot snapshot exists, error: %s", err)
+		return false
+	}
+	if exist {
+		log.Printf("")
+		log.Printf("The template already has the quickboot snapshot, skipping warm-up")
+	}
+	return exist
+}
+
+func (p *pipeline) storeAVDTemplate() error {
+	if p.materialized {
+		if err := p.templateCache.Replace(p.templateKey, p.configs.Name, p.androidHome, p.spec, cancellation.staged); err != nil {
+			return fmt.Errorf("failed to update AVD template with the quickboot snapshot, error: %s", err)
+		}
+		log.Donef("Template updated with the quickboot snapshot: %s", p.templateCache.TemplateDir(p.templateKey))
+		return nil
+	}
+
+	if err := p.templateCache.Store(p.templateKey, p.configs.Name, p.androidHome, p.spec, cancellation.staged); err != nil {
+		return fmt.Errorf("failed to store AVD template, error: %s", err)
+	}
+	log.Donef("Template stored: %s", p.templateCache.TemplateDir(p.templateKey))
+	return nil
+}
+
+func (p *pipeline) boot() error {
+	var capture *logcapture.Config
+	if p.configs.CaptureLogs == "yes" {
+		capture = p.configs.logCaptureConfig()
+		log.Printf("Capturing logs into: %s", capture.Dir)
+	}
+
+	if _, err := bootEmulator(p.androidHome, p.configs.Name, p.port, p.emulatorOptions, p.bootTimeout, capture); err != nil {
+		return fmt.Errorf("failed to boot emulator, error: %s", err)
+	}
+
+	log.Donef("Booted: %s", p.serial)
+	return nil
+}
+
+func (p *pipeline) prepareDevice() error {
+	results, err := prepareDevice(p.androidHome, p.serial, emulator.PrepareOptions{
+		Locale:         p.configs.DeviceLocale,
+		Timezone:       p.configs.DeviceTimezone,
+		RestartTimeout: p.bootTimeout,
+	})
+	runReport.setPreparation(results)
+	if err != nil {
+		return fmt.Errorf("failed to prepare device, error: %s", err)
+	}
+	return nil
+}
+
+func (p *pipeline) exportOutputs() error {
+	log.Printf("Using exporter: %s", p.envExporter)
+
+	manifest, err := newAVDManifest(p.configs.Name, p.androidHome, p.systemImage)
+	if err != nil {
+		return fmt.Errorf("failed to describe the created AVD, error: %s", err)
+	}
+	manifest.Port = p.port
+	manifest.Serial = p.serial
+	manifest.ArchivePath = p.archivePth
+	if p.configs.CaptureLogs == "yes" {
+		manifest.LogsDir = logsDir(p.configs.Name)
+	}
+
+	cache, err := newCacheModel(p.configs.Name, p.androidHome, p.platform, p.systemImage, p.configs.CacheIncludeAVD == "yes")
+	if err != nil {
+		return fmt.Errorf("failed to collect the cache paths, error: %s", err)
+	}
+	manifest.Cache = cache
+
+	manifestPth := manifestPath(p.configs.Name)
+	if err := writeAVDManifest(manifestPth, manifest); err != nil {
+		return newStepError(errExportFailed, err, "failed to write AVD manifest")
+	}
+
+	for _, output := range manifest.outputs(manifestPth) {
+		if err := p.envExporter.Export(output[0], output[1]); err != nil {
+			return newStepError(errExportFailed, err, "failed to export %s", output[0])
+		}
+		log.Printf("%s: %s", output[0], output[1])
+	}
+
+	log.Printf("")
+	log.Donef("Emulator name is exported in environment variable: %s (value: %s)", bitriseEmulatorName, p.configs.Name)
+	return nil
+}
+
+// PlannedStageModel describes what a stage would do.
+type PlannedStageModel struct {
+	Name    string `json:"name"`
+	Run     bool   `json:"run"`
+	Command string `json:"command,omitempty"`
+}
+
+// plan runs the read-only checks (installed components, template lookup) and returns the stages which would run,
+// with the SDK tool commands they would execute.
+func (p *pipeline) plan() ([]PlannedStageModel, error) {
+	if err := p.setup(); err != nil {
+		return nil, err
+	}
+
+	if err := p.checkPlatform(); err != nil {
+		return nil, err
+	}
+	if err := p.checkSystemImage(); err != nil {
+		return nil, err
+	}
+
+	if p.configs.TemplateCacheDir != "" {
+		spec, err := newAVDSpec(p.configs.Name, p.androidHome, p.systemImage, p.options, p.configs.HardwareProfilePreset, p.presetProfile, p.hardwareProfile)
+		if err != nil && p.systemImageInstalled {
+			return nil, fmt.Errorf("failed to describe the AVD spec, error: %s", err)
+		} else if err == nil {
+			if p.templateKey, err = spec.hash(); err != nil {
+				return nil, fmt.Errorf("failed to hash the AVD spec, error: %s", err)
+			}
+			if _, p.materialized, err = avd.NewTemplateCache(p.configs.TemplateCacheDir).Lookup(p.templateKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var planned []PlannedStageModel
+	for _, s := range p.stages() {
+		stage := PlannedStageModel{Name: s.name, Run: s.skip == nil || !s.skip()}
+		if stage.Run {
+			switch s.name {
+			case "install_platform":
+				stage.Command = p.installer.InstallCommand(p.platform).PrintableCommandArgs()
+			case "install_system_image":
+				stage.Command = p.installer.InstallCommand(p.systemImage).PrintableCommandArgs()
+			case "create_avd":
+				stage.Command = p.creator.CreateCommand(p.configs.Name, p.systemImage, p.options).PrintableCommandArgs()
+			case "warm_up_snapshot":
+				// the planned warm-up is expected to succeed, so the template is updated with its snapshot
+				p.warmedUp = true
+			}
+		}
+		planned = append(planned, stage)
+	}
+	return planned, nil
+}
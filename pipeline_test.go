@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+// fakeCommand is a prepared SDK tool command which runs the given function.
+type fakeCommand struct {
+	args string
+	run  func() error
+}
+
+func (cmd fakeCommand) PrintableCommandArgs() string {
+	return cmd.args
+}
+
+func (cmd fakeCommand) Run() error {
+	return cmd.run()
+}
+
+// fakeInstaller reports the components of the installed map as installed,
+// its install commands fail with installErr or add the component to the map, unless notFound is set.
+type fakeInstaller struct {
+	installed  map[string]bool
+	installErr error
+	notFound   bool
+	// command overrides the install command of the components, if set.
+	command  func(component sdkcomponent.Model) runnableCommand
+	installs []string
+}
+
+func (installer *fakeInstaller) IsInstalled(component sdkcomponent.Model) (bool, error) {
+	return installer.installed[component.GetSDKStylePath()], nil
+}
+
+func (installer *fakeInstaller) InstallCommand(component sdkcomponent.Model) runnableCommand {
+	if installer.command != nil {
+		return installer.command(component)
+	}
+
+	pth := component.GetSDKStylePath()
+	return fakeCommand{
+		args: "sdkmanager " + pth,
+		run: func() error {
+			installer.installs = append(installer.installs, pth)
+			if installer.installErr != nil {
+				return installer.installErr
+			}
+			if !installer.notFound {
+				installer.installed[pth] = true
+			}
+			return nil
+		},
+	}
+}
+
+// fakeCreator creates the AVD dir with an empty config.ini, or fails with createErr.
+type fakeCreator struct {
+	createErr error
+	notFound  bool
+}
+
+func (creator *fakeCreator) CreateCommand(name string, systemImage sdkcomponent.SystemImage, options []string) runnableCommand {
+	return fakeCommand{
+		args: "avdmanager create avd --name " + name,
+		run: func() error {
+			if creator.createErr != nil {
+				return creator.createErr
+			}
+			if creator.notFound {
+				return nil
+			}
+			if err := os.MkdirAll(avd.Dir(name), 0755); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(avd.ConfigPath(name), nil, 0644)
+		},
+	}
+}
+
+// fakeProfileWriter records the written profiles by config.ini path.
+type fakeProfileWriter struct {
+	written map[string]hardwareprofile.Profile
+}
+
+func (profiles *fakeProfileWriter) Write(configPth string, profile hardwareprofile.Profile) error {
+	profiles.written[configPth] = profile
+	return nil
+}
+
+// fakeExporter records the exported values.
+type fakeExporter struct {
+	exported map[string]string
+}
+
+func (envExporter *fakeExporter) Export(key, value string) error {
+	envExporter.exported[key] = value
+	return nil
+}
+
+func (envExporter *fakeExporter) String() string {
+	return "fake"
+}
+
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	_ = os.Setenv(key, value)
+	return func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
+
+// setupPipelineTest creates an Android SDK with the source.properties of the default platform and system image,
+// and points the AVD home, the deploy dir and the temp dir (port reservations) into a temp dir.
+func setupPipelineTest(t *testing.T) (string, func()) {
+	tmpDir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+
+	androidHome := filepath.Join(tmpDir, "sdk")
+	properties := map[string]string{
+		"platforms/android-19":                         "Pkg.Revision=4\n",
+		"system-images/android-19/default/armeabi-v7a": "Pkg.Revision=5\nAndroidVersion.ApiLevel=19\n",
+	}
+	for dir, content := range properties {
+		pth := filepath.Join(androidHome, filepath.FromSlash(dir))
+		if err := os.MkdirAll(pth, 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", pth, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pth, "source.properties"), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write source.properties: %s", err)
+		}
+	}
+
+	for _, dir := range []string{"avd", "deploy", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", dir, err)
+		}
+	}
+
+	restores := []func(){
+		setenv("ANDROID_AVD_HOME", filepath.Join(tmpDir, "avd")),
+		setenv("BITRISE_DEPLOY_DIR", filepath.Join(tmpDir, "deploy")),
+		setenv("TMPDIR", filepath.Join(tmpDir, "tmp")),
+	}
+	runReport = &RunReportModel{StartTime: time.Now(), Outcome: outcomeRunning}
+
+	return androidHome, func() {
+		for _, restore := range restores {
+			restore()
+		}
+		_ = os.RemoveAll(tmpDir)
+	}
+}
+
+func testConfigs(androidHome string) ConfigsModel {
+	configs := defaultConfigs()
+	configs.Name = "test"
+	configs.AndroidHome = androidHome
+	configs.AccelerationCheck = "off"
+	return configs
+}
+
+func TestPipelineRun(t *testing.T) {
+	platform := sdkcomponent.Platform{Version: "android-19"}.GetSDKStylePath()
+	systemImage := sdkcomponent.SystemImage{Platform: "android-19", Tag: "default", ABI: "armeabi-v7a"}.GetSDKStylePath()
+
+	licenseCommand := func(component sdkcomponent.Model) runnableCommand {
+		output := &bytes.Buffer{}
+		cmd := command.New("sh", "-c", "echo 'License for package Android SDK Platform 19 not accepted.' >&2; exit 1")
+		cmd.SetStderr(output)
+		return sdkManagerCommand{Model: cmd, output: output}
+	}
+
+	tests := []struct {
+		name           string
+		installer      *fakeInstaller
+		creator        *fakeCreator
+		profileContent string
+		wantInstalls   []string
+		wantKind       ErrorKind
+		wantStage      string
+	}{
+		{
+			name:      "components installed",
+			installer: &fakeInstaller{installed: map[string]bool{platform: true, systemImage: true}},
+			creator:   &fakeCreator{},
+		},
+		{
+			name:           "install succeeds",
+			installer:      &fakeInstaller{installed: map[string]bool{}},
+			creator:        &fakeCreator{},
+			profileContent: "hw.ramSize=1024",
+			wantInstalls:   []string{platform, systemImage},
+		},
+		{
+			name:         "install fails",
+			installer:    &fakeInstaller{installed: map[string]bool{}, installErr: errors.New("exit status 1")},
+			creator:      &fakeCreator{},
+			wantInstalls: []string{platform},
+			wantKind:     errInstallFailed,
+			wantStage:    "install_platform",
+		},
+		{
+			name:         "installed component not found",
+			installer:    &fakeInstaller{installed: map[string]bool{platform: true}, notFound: true},
+			creator:      &fakeCreator{},
+			wantInstalls: []string{systemImage},
+			wantKind:     errInstallFailed,
+			wantStage:    "install_system_image",
+		},
+		{
+			name:      "license missing",
+			installer: &fakeInstaller{installed: map[string]bool{}, command: licenseCommand},
+			creator:   &fakeCreator{},
+			wantKind:  errLicenseMissing,
+			wantStage: "install_platform",
+		},
+		{
+			name:      "create fails",
+			installer: &fakeInstaller{installed: map[string]bool{platform: true, systemImage: true}},
+			creator:   &fakeCreator{createErr: errors.New("exit status 1")},
+			wantKind:  errAVDCreateFailed,
+			wantStage: "create_avd",
+		},
+		{
+			name:      "created AVD not found",
+			installer: &fakeInstaller{installed: map[string]bool{platform: true, systemImage: true}},
+			creator:   &fakeCreator{notFound: true},
+			wantKind:  errAVDCreateFailed,
+			wantStage: "create_avd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			androidHome, cleanup := setupPipelineTest(t)
+			defer cleanup()
+
+			configs := testConfigs(androidHome)
+			configs.CustomHardwareProfileContent = tt.profileContent
+			profiles := &fakeProfileWriter{written: map[string]hardwareprofile.Profile{}}
+			envExporter := &fakeExporter{exported: map[string]string{}}
+
+			planned, err := newPipeline(configs, androidHome, tt.installer, tt.creator, profiles, envExporter).plan()
+			if err != nil {
+				t.Fatalf("plan() error = %s", err)
+			}
+
+			err = newPipeline(configs, androidHome, tt.installer, tt.creator, profiles, envExporter).run()
+			if tt.wantKind == "" {
+				if err != nil {
+					t.Fatalf("run() error = %s", err)
+				}
+			} else if err == nil {
+				t.Fatalf("run() error = nil, want %s", tt.wantKind)
+			} else if kind := errorKind(err, errFailed); kind != tt.wantKind {
+				t.Fatalf("run() error kind = %s (%s), want %s", kind, err, tt.wantKind)
+			}
+
+			if !reflect.DeepEqual(tt.installer.installs, tt.wantInstalls) {
+				t.Errorf("installs = %v, want %v", tt.installer.installs, tt.wantInstalls)
+			}
+
+			phases := runReport.Phases
+			if len(phases) == 0 {
+				t.Fatalf("no phases recorded")
+			}
+			if tt.wantStage != "" {
+				if name := phases[len(phases)-1].Name; name != tt.wantStage {
+					t.Errorf("failed stage = %s, want %s", name, tt.wantStage)
+				}
+			} else if len(phases) != len(planned) {
+				t.Errorf("ran %d stages, planned %d", len(phases), len(planned))
+			}
+
+			// the stages run in the planned order, with the planned commands
+			for i, phase := range phases {
+				if i >= len(planned) {
+					break
+				}
+				got := PlannedStageModel{Name: phase.Name, Run: phase.Outcome != outcomeSkipped, Command: phase.Command}
+				if !reflect.DeepEqual(got, planned[i]) {
+					t.Errorf("stage %d = %+v, planned %+v", i, got, planned[i])
+				}
+			}
+
+			if tt.wantKind != "" {
+				return
+			}
+			if name := envExporter.exported[bitriseEmulatorName]; name != configs.Name {
+				t.Errorf("exported %s = %s, want %s", bitriseEmulatorName, name, configs.Name)
+			}
+			if _, ok := profiles.written[avd.ConfigPath(configs.Name)]; ok != (tt.profileContent != "") {
+				t.Errorf("profile written = %v, want %v", ok, tt.profileContent != "")
+			}
+		})
+	}
+}
+
+func TestPipelineStages(t *testing.T) {
+	androidHome, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	installer := &fakeInstaller{installed: map[string]bool{}}
+	planned, err := newPipeline(testConfigs(androidHome), androidHome, installer, &fakeCreator{}, &fakeProfileWriter{}, &fakeExporter{}).plan()
+	if err != nil {
+		t.Fatalf("plan() error = %s", err)
+	}
+
+	want := []PlannedStageModel{
+		{Name: "check_acceleration"},
+		{Name: "check_platform", Run: true},
+		{Name: "install_platform", Run: true, Command: "sdkmanager platforms;android-19"},
+		{Name: "check_system_image", Run: true},
+		{Name: "install_system_image", Run: true, Command: "sdkmanager system-images;android-19;default;armeabi-v7a"},
+		{Name: "materialize_avd"},
+		{Name: "create_avd", Run: true, Command: "avdmanager create avd --name test"},
+		{Name: "apply_hardware_profile"},
+		{Name: "allocate_port", Run: true},
+		{Name: "warm_up_snapshot"},
+		{Name: "store_avd_template"},
+		{Name: "export_avd"},
+		{Name: "boot"},
+		{Name: "prepare_device"},
+		{Name: "export", Run: true},
+	}
+	if !reflect.DeepEqual(planned, want) {
+		t.Errorf("plan() = %+v, want %+v", planned, want)
+	}
+}
+
+func TestPipelinePortReservationFailure(t *testing.T) {
+	platform := sdkcomponent.Platform{Version: "android-19"}.GetSDKStylePath()
+	systemImage := sdkcomponent.SystemImage{Platform: "android-19", Tag: "default", ABI: "armeabi-v7a"}.GetSDKStylePath()
+
+	for _, warmUp := range []string{"no", "yes"} {
+		androidHome, cleanup := setupPipelineTest(t)
+
+		// the requested port is reserved for an other existing AVD
+		if err := os.MkdirAll(avd.Dir("other"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := emulator.NewPortAllocator(avd.Home()).ReservePort("other", 5584); err != nil {
+			t.Fatalf("ReservePort() error: %s", err)
+		}
+
+		configs := testConfigs(androidHome)
+		configs.EmulatorPort = "5584"
+		configs.WarmUpSnapshot = warmUp
+		installer := &fakeInstaller{installed: map[string]bool{platform: true, systemImage: true}}
+		envExporter := &fakeExporter{exported: map[string]string{}}
+
+		err := newPipeline(configs, androidHome, installer, &fakeCreator{}, &fakeProfileWriter{}, envExporter).run()
+		if warmUp == "yes" {
+			if err == nil {
+				t.Errorf("warm-up: run() error = nil, want the port reservation error")
+			} else if phases := runReport.Phases; phases[len(phases)-1].Name != "allocate_port" {
+				t.Errorf("warm-up: failed stage = %s, want allocate_port", phases[len(phases)-1].Name)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("no warm-up: run() error: %s", err)
+			}
+			for _, key := range []string{bitriseEmulatorPort, bitriseEmulatorSerial} {
+				if value, ok := envExporter.exported[key]; ok {
+					t.Errorf("no warm-up: %s = %s exported without a reserved port", key, value)
+				}
+			}
+			if envExporter.exported[bitriseEmulatorName] != configs.Name {
+				t.Errorf("no warm-up: %s not exported", bitriseEmulatorName)
+			}
+		}
+
+		cleanup()
+	}
+}
+
+func TestPipelineKeepsStdoutClean(t *testing.T) {
+	androidHome, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	log.SetOutWriter(ioutil.Discard)
+	defer func() {
+		os.Stdout = stdout
+		log.SetOutWriter(stdout)
+	}()
+
+	configs := testConfigs(androidHome)
+	configs.CustomHardwareProfileContent = "hw.ramSize=1024"
+	testPipeline := func() *pipeline {
+		installer := &fakeInstaller{installed: map[string]bool{}}
+		profiles := &fakeProfileWriter{written: map[string]hardwareprofile.Profile{}}
+		return newPipeline(configs, androidHome, installer, &fakeCreator{}, profiles, &fakeExporter{exported: map[string]string{}})
+	}
+	if _, err := testPipeline().plan(); err != nil {
+		t.Errorf("plan() error: %s", err)
+	}
+	if err := testPipeline().run(); err != nil {
+		t.Errorf("run() error: %s", err)
+	}
+
+	os.Stdout = stdout
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ioutil.ReadAll(r); err != nil || len(out) != 0 {
+		t.Errorf("the pipeline wrote %q (%v) to the stdout, want everything logged", out, err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+)
+
+// localePattern matches the language tags accepted by persist.sys.locale, like en, en-US or zh-Hans-CN.
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// timezonePattern matches the timezone IDs accepted by persist.sys.timezone, like Europe/Budapest or Etc/GMT+2.
+// The value ends up in a device shell command line, so only these characters are allowed.
+var timezonePattern = regexp.MustCompile(`^[A-Za-z0-9_+\-/]+$`)
+
+// prepareDevice applies the UI testing settings on the booted emulator and logs the verified results,
+// it fails if any of the settings could not be verified.
+func prepareDevice(androidHome, serial string, opts emulator.PrepareOptions) ([]emulator.PrepareResult, error) {
+	adbTool, err := emulator.NewADB(androidHome)
+	if err != nil {
+		return nil, err
+	}
+
+	results := emulator.PrepareDevice(newDeviceClient(adbTool), serial, opts)
+
+	var failed []string
+	for _, result := range results {
+		if result.Verified {
+			log.Donef("- %s: %s", result.Name, result.Actual)
+			continue
+		}
+
+		if result.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", result.Name, result.Error))
+		} else {
+			failed = append(failed, result.Name)
+		}
+		log.Errorf("- %s: expected: %s, actual: %s", result.Name, result.Expected, result.Actual)
+		if result.Error != "" {
+			log.Printf("  %s", result.Error)
+		}
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("failed to verify: %s", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
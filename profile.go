@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+// customHardwareProfile expands the template values in the CustomHardwareProfileContent input,
+// parses it and checks its keys against the emulator's hardware-properties.ini.
+func customHardwareProfile(configs ConfigsModel, systemImage sdkcomponent.SystemImage) (hardwareprofile.Profile, error) {
+	if configs.CustomHardwareProfileContent == "" {
+		return hardwareprofile.Profile{}, nil
+	}
+
+	content, err := hardwareprofile.Expand(configs.CustomHardwareProfileContent, hardwareprofile.TemplateData{
+		Name:           configs.Name,
+		Platform:       systemImage.Platform,
+		ABI:            systemImage.ABI,
+		Tag:            systemImage.Tag,
+		SystemImageDir: filepath.ToSlash(systemImage.InstallPathInAndroidHome()) + "/",
+		AndroidHome:    configs.AndroidHome,
+	})
+	if err != nil {
+		return hardwareprofile.Profile{}, fmt.Errorf("failed to expand template values: %s", err)
+	}
+
+	profile, err := hardwareprofile.Parse(content)
+	if err != nil {
+		return hardwareprofile.Profile{}, err
+	}
+
+	if err := checkHardwareProfileKeys(configs.AndroidHome, profile); err != nil {
+		return hardwareprofile.Profile{}, err
+	}
+
+	return profile, nil
+}
+
+// checkHardwareProfileKeys checks the profile keys against the emulator's hardware-properties.ini,
+// prints the warnings and returns the errors.
+func checkHardwareProfileKeys(androidHome string, profile hardwareprofile.Profile) error {
+	definitions, err := hardwareprofile.LoadDefinitions(androidHome)
+	if os.IsNotExist(err) {
+		log.Warnf("No hardware-properties.ini found in ANDROID_HOME, skipping hardware profile key check")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read hardware-properties.ini, error: %s", err)
+	}
+
+	var errs []string
+	for _, issue := range definitions.Check(profile) {
+		if issue.Severity == hardwareprofile.SeverityError {
+			errs = append(errs, issue.String())
+		} else {
+			log.Warnf("%s", issue)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// resolveHardwareProfileConflicts checks the profile's system image keys against the inputs.
+// In strict mode the conflicts are returned as an error, otherwise they are corrected in the profile
+// and the corrections are returned as warnings.
+func resolveHardwareProfileConflicts(profile *hardwareprofile.Profile, systemImage sdkcomponent.SystemImage, strict bool) ([]string, error) {
+	conflicts := hardwareprofile.CheckConsistency(*profile, systemImage)
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	var messages []string
+	for _, conflict := range conflicts {
+		messages = append(messages, conflict.String())
+	}
+	if strict {
+		return nil, errors.New(strings.Join(messages, "\n"))
+	}
+
+	var warnings []string
+	for _, message := range messages {
+		warnings = append(warnings, "Correcting custom hardware profile: "+message)
+	}
+	return warnings, profile.Fix(conflicts)
+}
+
+// mergePresetHardwareProfile applies the preset and then the custom profile
+// on top of the config.ini created by avdmanager.
+func mergePresetHardwareProfile(configPth string, preset, custom hardwareprofile.Profile) (hardwareprofile.Profile, error) {
+	profile := hardwareprofile.Profile{}
+
+	if exist, err := pathutil.IsPathExists(configPth); err != nil {
+		return hardwareprofile.Profile{}, err
+	} else if exist {
+		content, err := fileutil.ReadStringFromFile(configPth)
+		if err != nil {
+			return hardwareprofile.Profile{}, err
+		}
+
+		// only the preset and the custom profile are validated, avdmanager writes values out of the checked ranges for small devices
+		if profile, err = hardwareprofile.ParseLenient(content); err != nil {
+			return hardwareprofile.Profile{}, fmt.Errorf("failed to parse config.ini created by avdmanager (%s):\n%s", configPth, err)
+		}
+	}
+
+	if err := profile.Merge(preset); err != nil {
+		return hardwareprofile.Profile{}, err
+	}
+	if err := profile.Merge(custom); err != nil {
+		return hardwareprofile.Profile{}, err
+	}
+	return profile, nil
+}
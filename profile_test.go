@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+func TestResolveHardwareProfileConflicts(t *testing.T) {
+	systemImage := sdkcomponent.SystemImage{Platform: "android-28", Tag: "google_apis", ABI: "x86_64"}
+	content := "abi.type=x86\ntag.id=google_apis\nhw.ramSize=2048\n"
+
+	t.Run("strict", func(t *testing.T) {
+		profile, err := hardwareprofile.Parse(content)
+		if err != nil {
+			t.Fatalf("Parse() error: %s", err)
+		}
+
+		warnings, err := resolveHardwareProfileConflicts(&profile, systemImage, true)
+		if err == nil {
+			t.Fatalf("resolveHardwareProfileConflicts() error = nil, want the abi.type conflict")
+		}
+		if !strings.Contains(err.Error(), "abi.type") {
+			t.Errorf("resolveHardwareProfileConflicts() error = %s, want the abi.type conflict", err)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("resolveHardwareProfileConflicts() warnings = %v, want none", warnings)
+		}
+		if value, _ := profile.Get("abi.type"); value != "x86" {
+			t.Errorf("abi.type = %s, want the profile unchanged in strict mode", value)
+		}
+	})
+
+	t.Run("auto-correction", func(t *testing.T) {
+		profile, err := hardwareprofile.Parse(content)
+		if err != nil {
+			t.Fatalf("Parse() error: %s", err)
+		}
+
+		warnings, err := resolveHardwareProfileConflicts(&profile, systemImage, false)
+		if err != nil {
+			t.Fatalf("resolveHardwareProfileConflicts() error: %s", err)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "abi.type") {
+			t.Errorf("resolveHardwareProfileConflicts() warnings = %v, want the abi.type correction", warnings)
+		}
+		if value, _ := profile.Get("abi.type"); value != "x86_64" {
+			t.Errorf("abi.type = %s, want the corrected x86_64", value)
+		}
+		if value, _ := profile.Get("hw.ramSize"); value != "2048" {
+			t.Errorf("hw.ramSize = %s, want the unrelated key kept", value)
+		}
+	})
+
+	t.Run("consistent", func(t *testing.T) {
+		profile, err := hardwareprofile.Parse("abi.type=x86_64\n")
+		if err != nil {
+			t.Fatalf("Parse() error: %s", err)
+		}
+
+		warnings, err := resolveHardwareProfileConflicts(&profile, systemImage, true)
+		if err != nil || len(warnings) != 0 {
+			t.Errorf("resolveHardwareProfileConflicts() = %v, %v, want no warnings and no error", warnings, err)
+		}
+	})
+}
+
+func TestPipelineRejectsInvalidProfileTemplate(t *testing.T) {
+	for _, content := range []string{"abi.type={{.Abi}}\n", `hw.ramSize={{env "HARDWARE_PROFILE_TEST_UNSET"}}`, "abi.type={{.ABI\n"} {
+		androidHome, cleanup := setupPipelineTest(t)
+
+		configs := testConfigs(androidHome)
+		configs.CustomHardwareProfileContent = content
+		creator := &fakeCreator{}
+		profiles := &fakeProfileWriter{written: map[string]hardwareprofile.Profile{}}
+
+		err := newPipeline(configs, androidHome, &fakeInstaller{installed: map[string]bool{}}, creator, profiles, &fakeExporter{exported: map[string]string{}}).run()
+		if kind := errorKind(err, errFailed); err == nil || kind != errInvalidInput {
+			t.Errorf("%q: run() error = %v, want %s", content, err, errInvalidInput)
+		}
+		if len(runReport.Phases) != 0 {
+			t.Errorf("%q: ran stages %+v before the template error", content, runReport.Phases[0])
+		}
+		if len(profiles.written) != 0 {
+			t.Errorf("%q: config.ini written", content)
+		}
+
+		cleanup()
+	}
+}
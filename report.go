@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/emulator"
+)
+
+// Phase outcomes
+const (
+	outcomeRunning = "running"
+	outcomeSuccess = "success"
+	outcomeFailed  = "failed"
+	outcomeSkipped = "skipped"
+)
+
+// runReportFileName is the name of the run report in the deploy dir, the collect_logs mode writes its own report.
+var runReportFileName = "create-android-emulator-report.json"
+
+// PhaseModel is a timed section of the step run.
+type PhaseModel struct {
+	Name      string    `json:"name"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Duration  float64   `json:"duration_seconds"`
+	Outcome   string    `json:"outcome"`
+	Command   string    `json:"command,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RunReportModel is the machine-readable summary of the step run.
+// It is guarded by its mutex, as the cancellation fails the step from the signal handler goroutine.
+type RunReportModel struct {
+	mu sync.Mutex
+
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Duration  float64       `json:"duration_seconds"`
+	Outcome   string        `json:"outcome"`
+	Phases    []*PhaseModel `json:"phases"`
+	// Preparation lists the verified device settings, if the device was prepared.
+	Preparation []emulator.PrepareResult `json:"preparation,omitempty"`
+}
+
+var runReport = &RunReportModel{StartTime: time.Now(), Outcome: outcomeRunning}
+
+// startPhase finishes the running phase successfully and starts a new one.
+func (report *RunReportModel) startPhase(name string) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	report.finishPhase(outcomeSuccess, "")
+	report.Phases = append(report.Phases, &PhaseModel{Name: name, StartTime: time.Now(), Outcome: outcomeRunning})
+}
+
+// skipPhase records a phase which did not need to run.
+func (report *RunReportModel) skipPhase(name string) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	report.finishPhase(outcomeSuccess, "")
+	now := time.Now()
+	report.Phases = append(report.Phases, &PhaseModel{Name: name, StartTime: now, EndTime: now, Outcome: outcomeSkipped})
+}
+
+// setCommand records the command executed by the running phase.
+func (report *RunReportModel) setCommand(cmd string) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	if phase := report.runningPhase(); phase != nil {
+		phase.Command = cmd
+	}
+}
+
+// setPreparation records the verified device settings.
+func (report *RunReportModel) setPreparation(results []emulator.PrepareResult) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	report.Preparation = results
+}
+
+// runningPhaseName returns the name of the running phase, or an empty string if none is running.
+func (report *RunReportModel) runningPhaseName() string {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	if phase := report.runningPhase(); phase != nil {
+		return phase.Name
+	}
+	return ""
+}
+
+// runningPhase and finishPhase expect the caller to hold the lock.
+func (report *RunReportModel) runningPhase() *PhaseModel {
+	if len(report.Phases) == 0 {
+		return nil
+	}
+	if phase := report.Phases[len(report.Phases)-1]; phase.Outcome == outcomeRunning {
+		return phase
+	}
+	return nil
+}
+
+func (report *RunReportModel) finishPhase(outcome, errorMessage string) {
+	phase := report.runningPhase()
+	if phase == nil {
+		return
+	}
+
+	phase.EndTime = time.Now()
+	phase.Duration = phase.EndTime.Sub(phase.StartTime).Seconds()
+	phase.Outcome = outcome
+	phase.Error = errorMessage
+}
+
+// finish closes the running phase and the whole report with the given outcome.
+func (report *RunReportModel) finish(outcome, errorMessage string) {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	report.finishPhase(outcome, errorMessage)
+	report.EndTime = time.Now()
+	report.Duration = report.EndTime.Sub(report.StartTime).Seconds()
+	report.Outcome = outcome
+}
+
+// deployPath returns the path of the given file in the deploy dir,
+// or in the temp dir if BITRISE_DEPLOY_DIR is not set.
+func deployPath(fileName string) string {
+	dir := os.Getenv("BITRISE_DEPLOY_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fileName)
+}
+
+func (report *RunReportModel) write(pth string) error {
+	report.mu.Lock()
+	b, err := json.MarshalIndent(report, "", "  ")
+	report.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteBytesToFile(pth, b)
+}
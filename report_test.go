@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+// readRunReport finishes the run report with the outcome, writes it into the deploy dir and reads it back.
+func readRunReport(t *testing.T, outcome, errorMessage string) map[string]interface{} {
+	runReport.finish(outcome, errorMessage)
+
+	pth := deployPath(runReportFileName)
+	if err := runReport.write(pth); err != nil {
+		t.Fatalf("write() error: %s", err)
+	}
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		t.Fatalf("failed to read the report: %s", err)
+	}
+
+	var report map[string]interface{}
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatalf("failed to parse the report: %s", err)
+	}
+	return report
+}
+
+func reportPhases(report map[string]interface{}) [][2]string {
+	var phases [][2]string
+	for _, phase := range report["phases"].([]interface{}) {
+		fields := phase.(map[string]interface{})
+		phases = append(phases, [2]string{fields["name"].(string), fields["outcome"].(string)})
+	}
+	return phases
+}
+
+func TestRunReport(t *testing.T) {
+	platform := sdkcomponent.Platform{Version: "android-19"}.GetSDKStylePath()
+	systemImage := sdkcomponent.SystemImage{Platform: "android-19", Tag: "default", ABI: "armeabi-v7a"}.GetSDKStylePath()
+
+	t.Run("success", func(t *testing.T) {
+		androidHome, cleanup := setupPipelineTest(t)
+		defer cleanup()
+
+		installer := &fakeInstaller{installed: map[string]bool{platform: true}}
+		p := newPipeline(testConfigs(androidHome), androidHome, installer, &fakeCreator{}, &fakeProfileWriter{}, &fakeExporter{exported: map[string]string{}})
+		if err := p.run(); err != nil {
+			t.Fatalf("run() error: %s", err)
+		}
+
+		report := readRunReport(t, outcomeSuccess, "")
+		if report["outcome"] != outcomeSuccess {
+			t.Errorf("outcome = %v, want %s", report["outcome"], outcomeSuccess)
+		}
+		if _, ok := report["duration_seconds"].(float64); !ok {
+			t.Errorf("duration_seconds = %v, want a number", report["duration_seconds"])
+		}
+
+		want := map[string]string{
+			"check_acceleration":   outcomeSkipped,
+			"install_platform":     outcomeSkipped,
+			"install_system_image": outcomeSuccess,
+			"create_avd":           outcomeSuccess,
+			"export":               outcomeSuccess,
+		}
+		for _, phase := range reportPhases(report) {
+			if outcome, ok := want[phase[0]]; ok && outcome != phase[1] {
+				t.Errorf("phase %s outcome = %s, want %s", phase[0], phase[1], outcome)
+			}
+			if phase[1] == outcomeRunning {
+				t.Errorf("phase %s is still running", phase[0])
+			}
+		}
+
+		for _, phase := range report["phases"].([]interface{}) {
+			fields := phase.(map[string]interface{})
+			if fields["name"] == "install_system_image" && fields["command"] != "sdkmanager "+systemImage {
+				t.Errorf("install_system_image command = %v, want sdkmanager %s", fields["command"], systemImage)
+			}
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		androidHome, cleanup := setupPipelineTest(t)
+		defer cleanup()
+
+		installer := &fakeInstaller{installed: map[string]bool{platform: true, systemImage: true}}
+		creator := &fakeCreator{createErr: errors.New("exit status 1")}
+		err := newPipeline(testConfigs(androidHome), androidHome, installer, creator, &fakeProfileWriter{}, &fakeExporter{exported: map[string]string{}}).run()
+		if err == nil {
+			t.Fatalf("run() error = nil, want the create error")
+		}
+
+		report := readRunReport(t, outcomeFailed, err.Error())
+		if report["outcome"] != outcomeFailed {
+			t.Errorf("outcome = %v, want %s", report["outcome"], outcomeFailed)
+		}
+
+		phases := reportPhases(report)
+		if last := phases[len(phases)-1]; !reflect.DeepEqual(last, [2]string{"create_avd", outcomeFailed}) {
+			t.Errorf("last phase = %v, want the failed create_avd", last)
+		}
+		last := report["phases"].([]interface{})[len(phases)-1].(map[string]interface{})
+		if last["error"] != err.Error() {
+			t.Errorf("create_avd error = %v, want %s", last["error"], err)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-tools/go-android/avdmanager"
+	"github.com/bitrise-tools/go-android/sdk"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+	"github.com/bitrise-tools/go-android/sdkmanager"
+)
+
+// runnableCommand is a prepared SDK tool command, its printable form is recorded in the run report.
+type runnableCommand interface {
+	PrintableCommandArgs() string
+	Run() error
+}
+
+// componentInstaller checks and installs SDK components, like platforms and system images.
+type componentInstaller interface {
+	IsInstalled(component sdkcomponent.Model) (bool, error)
+	InstallCommand(component sdkcomponent.Model) runnableCommand
+}
+
+// avdCreator creates AVDs from installed system images.
+type avdCreator interface {
+	CreateCommand(name string, systemImage sdkcomponent.SystemImage, options []string) runnableCommand
+}
+
+// profileWriter writes the hardware profile (config.ini) of AVDs.
+type profileWriter interface {
+	Write(configPth string, profile hardwareprofile.Profile) error
+}
+
+// sdkManagerInstaller installs the components with the sdkmanager, accepting the licenses.
+type sdkManagerInstaller struct {
+	manager *sdkmanager.Model
+}
+
+// IsInstalled ...
+func (installer sdkManagerInstaller) IsInstalled(component sdkcomponent.Model) (bool, error) {
+	return installer.manager.IsInstalled(component)
+}
+
+// InstallCommand ...
+func (installer sdkManagerInstaller) InstallCommand(component sdkcomponent.Model) runnableCommand {
+	output := &bytes.Buffer{}
+	cmd := installer.manager.InstallCommand(component)
+	cmd.SetStdin(strings.NewReader("y"))
+	cmd.SetStdout(io.MultiWriter(os.Stdout, output))
+	cmd.SetStderr(io.MultiWriter(os.Stderr, output))
+	return sdkManagerCommand{Model: cmd, output: output}
+}
+
+// licensePattern matches the sdkmanager errors of the not accepted licenses.
+var licensePattern = regexp.MustCompile(`(?i)licen[cs]e.*not (been )?accepted|not accepted the licen[cs]e`)
+
+// sdkManagerCommand reports the install failures caused by not accepted licenses as errLicenseMissing.
+type sdkManagerCommand struct {
+	*command.Model
+	output *bytes.Buffer
+}
+
+// Run ...
+func (cmd sdkManagerCommand) Run() error {
+	err := cancellation.run(cmd.GetCmd())
+	if err != nil && licensePattern.Match(cmd.output.Bytes()) {
+		return newStepError(errLicenseMissing, err, "the SDK licenses are not accepted, accept them with sdkmanager --licenses or copy the license files into $ANDROID_HOME/licenses")
+	}
+	return err
+}
+
+// avdManagerCreator creates the AVDs with the avdmanager, declining the custom hardware profile prompt.
+type avdManagerCreator struct {
+	manager *avdmanager.Model
+}
+
+// CreateCommand ...
+func (creator avdManagerCreator) CreateCommand(name string, systemImage sdkcomponent.SystemImage, options []string) runnableCommand {
+	cmd := creator.manager.CreateAVDCommand(name, systemImage, options...)
+	cmd.SetStdin(strings.NewReader("n"))
+	cmd.SetStdout(os.Stdout)
+	cmd.SetStderr(os.Stderr)
+	return cancelableCommand{Model: cmd}
+}
+
+// cancelableCommand runs the command in its own process group, which receives the termination signal of the step.
+type cancelableCommand struct {
+	*command.Model
+}
+
+// Run ...
+func (cmd cancelableCommand) Run() error {
+	return cancellation.run(cmd.GetCmd())
+}
+
+// fileProfileWriter overwrites the config.ini with the profile.
+type fileProfileWriter struct{}
+
+// Write ...
+func (fileProfileWriter) Write(configPth string, profile hardwareprofile.Profile) error {
+	return fileutil.WriteStringToFile(configPth, profile.String())
+}
+
+// newSDKTools returns the sdkmanager and avdmanager backed tools of the Android SDK.
+func newSDKTools(androidSdk *sdk.Model) (componentInstaller, avdCreator, error) {
+	manager, err := sdkmanager.New(androidSdk)
+	if err != nil {
+		return nil, nil, newStepError(errSDKToolMissing, err, "failed to create sdk manager")
+	}
+
+	avdManager, err := avdmanager.New(androidSdk)
+	if err != nil {
+		return nil, nil, newStepError(errSDKToolMissing, err, "failed to create avd manager")
+	}
+
+	return sdkManagerInstaller{manager: manager}, avdManagerCreator{manager: avdManager}, nil
+}
@@ -12,4 +12,6 @@ rsync -avh --quiet "${THIS_SCRIPT_DIR}/" "${full_package_path}/"
 
 export GOPATH="${tmp_gopath_dir}"
 export GO15VENDOREXPERIMENT=1
-go run "${full_package_path}/main.go"
\ No newline at end of file
+# the step binary is kept, the log capture process started in the background re-executes it
+go build -o "${tmp_gopath_dir}/bin/step" "${go_package_name}"
+"${tmp_gopath_dir}/bin/step"
\ No newline at end of file
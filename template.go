@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/hardwareprofile"
+	"github.com/bitrise-tools/go-android/sdkcomponent"
+)
+
+// avdSpec is everything the AVD is created from, its hash keys the template cache.
+type avdSpec struct {
+	Name                  string   `json:"name"`
+	SystemImage           string   `json:"system_image"`
+	SystemImageRevision   string   `json:"system_image_revision"`
+	ABI                   string   `json:"abi"`
+	Tag                   string   `json:"tag"`
+	Options               []string `json:"options"`
+	HardwareProfilePreset string   `json:"hardware_profile_preset"`
+	PresetProfile         string   `json:"preset_profile"`
+	HardwareProfile       string   `json:"hardware_profile"`
+}
+
+func newAVDSpec(name, androidHome string, systemImage sdkcomponent.SystemImage, options []string, presetName string, preset *hardwareprofile.Profile, profile hardwareprofile.Profile) (avdSpec, error) {
+	properties, err := avd.ReadProperties(filepath.Join(androidHome, systemImage.InstallPathInAndroidHome(), "source.properties"))
+	if err != nil {
+		return avdSpec{}, fmt.Errorf("failed to read system image source.properties, error: %s", err)
+	}
+
+	spec := avdSpec{
+		Name:                  name,
+		SystemImage:           systemImage.GetSDKStylePath(),
+		SystemImageRevision:   properties["Pkg.Revision"],
+		ABI:                   systemImage.ABI,
+		Tag:                   systemImage.Tag,
+		Options:               options,
+		HardwareProfilePreset: presetName,
+		HardwareProfile:       profile.String(),
+	}
+	if preset != nil {
+		spec.PresetProfile = preset.String()
+	}
+	return spec, nil
+}
+
+// hash returns the hex sha256 of the spec's JSON form.
+func (spec avdSpec) hash() (string, error) {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// materializeAVDTemplate creates the AVD from the template stored with the given key,
+// returns false if there is no template for the key.
+func materializeAVDTemplate(cache avd.TemplateCache, key, androidHome string) (bool, error) {
+	if _, found, err := cache.Lookup(key); err != nil {
+		return false, err
+	} else if !found {
+		log.Printf("No template found for spec hash: %s", key)
+		return false, nil
+	}
+
+	if _, err := cache.Materialize(key, androidHome, cancellation.staged); err != nil {
+		return false, err
+	}
+
+	log.Donef("AVD materialized from template: %s", cache.TemplateDir(key))
+	return true, nil
+}
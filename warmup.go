@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+	"github.com/bitrise-steplib/steps-create-android-emulator/console"
+)
+
+const (
+	// quickbootSnapshot is the snapshot the emulator loads by default on start.
+	quickbootSnapshot       = "default_boot"
+	snapshotSaveTimeout     = 5 * time.Minute
+	emulatorShutdownTimeout = 2 * time.Minute
+)
+
+// warmUpSnapshot cold boots the AVD, saves the quickboot snapshot through the emulator console,
+// shuts the emulator down and checks that the snapshot was written into the AVD dir.
+func warmUpSnapshot(androidHome, name string, port int, options []string, timeout time.Duration) error {
+	emu, err := bootEmulator(androidHome, name, port, append(append([]string{}, options...), "-no-snapshot-load"), timeout, nil)
+	if err != nil {
+		return err
+	}
+
+	cancellation.track(emu.pid)
+	defer cancellation.untrack(emu.pid)
+
+	if err := saveSnapshotAndKill(port); err != nil {
+		killEmulator(emu)
+		return err
+	}
+
+	log.Printf("Waiting for the emulator to shut down")
+	select {
+	case <-emu.exited:
+	case <-time.After(emulatorShutdownTimeout):
+		killEmulator(emu)
+		return fmt.Errorf("emulator did not shut down in %s, the snapshot may be incomplete", emulatorShutdownTimeout)
+	}
+
+	snapshotDir := avd.SnapshotDir(name, quickbootSnapshot)
+	if exist, err := pathutil.IsPathExists(filepath.Join(snapshotDir, "snapshot.pb")); err != nil {
+		return err
+	} else if !exist {
+		return fmt.Errorf("snapshot not found at: %s", snapshotDir)
+	}
+
+	log.Donef("Snapshot saved: %s", snapshotDir)
+	return nil
+}
+
+func saveSnapshotAndKill(port int) error {
+	client, err := console.Dial(port)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the emulator console, error: %s", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	log.Printf("Saving snapshot: %s", quickbootSnapshot)
+	client.Timeout = snapshotSaveTimeout
+	if err := client.SaveSnapshot(quickbootSnapshot); err != nil {
+		return fmt.Errorf("failed to save snapshot, error: %s", err)
+	}
+
+	log.Printf("Shutting down the emulator")
+	if err := client.Kill(); err != nil {
+		return fmt.Errorf("failed to shut down the emulator, error: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-create-android-emulator/avd"
+)
+
+func TestSkipWarmUp(t *testing.T) {
+	tests := []struct {
+		name         string
+		warmUp       string
+		materialized bool
+		snapshot     bool
+		want         bool
+	}{
+		{name: "not requested", warmUp: "no", want: true},
+		{name: "created AVD", warmUp: "yes", want: false},
+		{name: "created AVD with a leftover snapshot", warmUp: "yes", snapshot: true, want: false},
+		{name: "template without snapshot", warmUp: "yes", materialized: true, want: false},
+		{name: "template with snapshot", warmUp: "yes", materialized: true, snapshot: true, want: true},
+	}
+
+	for _, tt := range tests {
+		androidHome, cleanup := setupPipelineTest(t)
+
+		configs := testConfigs(androidHome)
+		configs.WarmUpSnapshot = tt.warmUp
+		if tt.snapshot {
+			snapshotDir := avd.SnapshotDir(configs.Name, quickbootSnapshot)
+			if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(snapshotDir, "snapshot.pb"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p := newPipeline(configs, androidHome, &fakeInstaller{}, &fakeCreator{}, &fakeProfileWriter{}, &fakeExporter{})
+		p.materialized = tt.materialized
+		if got := p.skipWarmUp(); got != tt.want {
+			t.Errorf("%s: skipWarmUp() = %v, want %v", tt.name, got, tt.want)
+		}
+
+		cleanup()
+	}
+}